@@ -22,19 +22,33 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"errors"
 	"fmt"
-	"os"
 	"sort"
-	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/olekukonko/tablewriter"
+	"github.com/goccy/go-json"
+	"github.com/pepabo/trail-digger/report"
 	"github.com/pepabo/trail-digger/trail"
 	"github.com/spf13/cobra"
 )
 
+type analyzeState struct {
+	EventTypeCount          map[string]int `json:"eventTypeCount"`
+	EventSourceCount        map[string]int `json:"eventSourceCount"`
+	RegionCount             map[string]int `json:"regionCount"`
+	RecipientAccountIDCount map[string]int `json:"recipientAccountIdCount"`
+	// GroupByCount is the counts per --group-by. The keys of counts are the values of the fields joined with groupBySep
+	GroupByCount map[string]map[string]int `json:"groupByCount,omitempty"`
+	// Histogram is the counts per time bucket of --bucket
+	Histogram *report.Histogram `json:"histogram,omitempty"`
+}
+
+const groupBySep = "\x00"
+
 var analyzeCmd = &cobra.Command{
 	Use:   "analyze",
 	Short: "analyze AWS CloudTrail events using trail logs",
@@ -46,17 +60,110 @@ var analyzeCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		eventTypeCount := map[string]int{
-			"ManagementEvent": 0,
-			"DataEvent":       0,
+		if err := parseTimeRange(); err != nil {
+			return err
+		}
+		if err := validateReportFormat(analyzeFormat); err != nil {
+			return err
+		}
+		groups, err := parseGroupBy()
+		if err != nil {
+			return err
+		}
+		var h *report.Histogram
+		if bucket != "" {
+			if len(groups) > 0 {
+				return errors.New("--bucket and --group-by can not be used together")
+			}
+			d, err := trail.ParseDuration(bucket)
+			if err != nil || d < time.Second {
+				return fmt.Errorf("invalid --bucket: %s", bucket)
+			}
+			h = report.NewHistogram(d, strings.TrimSpace(splitBy))
+		} else if splitBy != "" {
+			return errors.New("--split-by requires --bucket")
+		}
+		state := &analyzeState{
+			EventTypeCount: map[string]int{
+				"ManagementEvent": 0,
+				"DataEvent":       0,
+			},
+			EventSourceCount:        map[string]int{},
+			RegionCount:             map[string]int{},
+			RecipientAccountIDCount: map[string]int{},
+			GroupByCount:            map[string]map[string]int{},
+			Histogram:               h,
 		}
-		eventSourceCount := map[string]int{}
-		regionCount := map[string]int{}
-		recipientAccountIDCount := map[string]int{}
-
 		var mu sync.Mutex
-		if err := trail.WalkEvents(sess, dsn, opt, func(r *trail.Record) error {
+		if err := loadCheckpoint(dsn); err != nil {
+			return err
+		}
+		if err := parseMaxMemory(); err != nil {
+			return err
+		}
+		if opt.Checkpoint != nil {
+			if len(opt.Checkpoint.State) > 0 {
+				// The histogram is replaced with the saved one to compare them
+				state.Histogram = nil
+				if err := json.Unmarshal(opt.Checkpoint.State, state); err != nil {
+					return err
+				}
+			}
+			// The counts restored from the checkpoint are only valid for the same options of analysis
+			if opt.Checkpoint.Resumed() {
+				if err := checkGroupByState(state.GroupByCount, groups); err != nil {
+					return err
+				}
+				if err := checkHistogramState(state.Histogram, h); err != nil {
+					return err
+				}
+			}
+			if state.Histogram == nil {
+				state.Histogram = h
+			}
+			opt.Checkpoint.SetStateFunc(func() (interface{}, error) {
+				return state, nil
+			})
+		}
+		eventTypeCount := state.EventTypeCount
+		eventSourceCount := state.EventSourceCount
+		regionCount := state.RegionCount
+		recipientAccountIDCount := state.RecipientAccountIDCount
+		if state.GroupByCount == nil {
+			state.GroupByCount = map[string]map[string]int{}
+		}
+		for _, g := range groups {
+			if state.GroupByCount[strings.Join(g, ",")] == nil {
+				state.GroupByCount[strings.Join(g, ",")] = map[string]int{}
+			}
+		}
+
+		if err := trail.WalkEventsWithContext(cmd.Context(), sess, dsn, opt, func(r *trail.Record) error {
+			var f map[string]interface{}
+			if len(groups) > 0 || splitBy != "" {
+				var err error
+				f, err = r.Fields()
+				if err != nil {
+					return err
+				}
+			}
 			mu.Lock()
+			for _, g := range groups {
+				values := make([]string, 0, len(g))
+				for _, p := range g {
+					v, _ := trail.Lookup(f, p)
+					values = append(values, trail.ValueString(v))
+				}
+				state.GroupByCount[strings.Join(g, ",")][strings.Join(values, groupBySep)] += 1
+			}
+			if h != nil {
+				key := ""
+				if h.SplitBy != "" {
+					v, _ := trail.Lookup(f, h.SplitBy)
+					key = trail.ValueString(v)
+				}
+				state.Histogram.Add(r.EventTime, key)
+			}
 			if r.ManagementEvent {
 				eventTypeCount["ManagementEvent"] += 1
 			} else {
@@ -71,71 +178,29 @@ var analyzeCmd = &cobra.Command{
 			return err
 		}
 
-		data := [][]string{}
-		data = append(data, []string{"", "", ""})
-
-		{
-			// Event Type
-			data = append(data, []string{"Event Type", "Management Event:", strconv.Itoa(eventTypeCount["ManagementEvent"])})
-			data = append(data, []string{"Event Type", "Data Event:", strconv.Itoa(eventTypeCount["DataEvent"])})
-			data = append(data, []string{"", "", ""})
-		}
-
-		{
-			// Event Source
-			keys := []string{}
-			for key := range eventSourceCount {
-				keys = append(keys, key)
-			}
-			sort.Strings(keys)
-			for _, key := range keys {
-				data = append(data, []string{"Event Source", fmt.Sprintf("%s:", key), strconv.Itoa(eventSourceCount[key])})
-			}
-			data = append(data, []string{"", "", ""})
+		if h != nil {
+			return state.Histogram.Write(cmd.OutOrStdout(), analyzeFormat)
 		}
-
-		{
-			// Region
-			keys := []string{}
-			for key := range regionCount {
-				keys = append(keys, key)
-			}
-			sort.Strings(keys)
-			for _, key := range keys {
-				data = append(data, []string{"Region", fmt.Sprintf("%s:", key), strconv.Itoa(regionCount[key])})
-			}
-			data = append(data, []string{"", "", ""})
-		}
-
-		{
-			// Recipient Account ID
-			keys := []string{}
-			for key := range recipientAccountIDCount {
-				keys = append(keys, key)
-			}
-			sort.Strings(keys)
-			for _, key := range keys {
-				data = append(data, []string{"Recipient Account ID", fmt.Sprintf("%s:", key), strconv.Itoa(recipientAccountIDCount[key])})
+		rep := report.New("count", "Count")
+		if len(groups) > 0 {
+			for _, g := range groups {
+				s := rep.AddGroupSection(g, toInt64(state.GroupByCount[strings.Join(g, ",")]), groupBySep)
+				s.Limit(top)
 			}
-			data = append(data, []string{"", "", ""})
+			return rep.Write(cmd.OutOrStdout(), analyzeFormat)
 		}
-
-		cmd.Println("")
-		table := tablewriter.NewWriter(os.Stdout)
-		table.SetHeader([]string{"", "", "Count"})
-		table.SetAutoWrapText(false)
-		table.SetAutoFormatHeaders(false)
-		table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
-		table.SetColumnAlignment([]int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_RIGHT})
-		table.SetCenterSeparator("")
-		table.SetColumnSeparator("")
-		table.SetRowSeparator("")
-		table.SetHeaderLine(false)
-		table.SetBorder(false)
-		table.SetAutoMergeCellsByColumnIndex([]int{0})
-		table.AppendBulk(data)
-		table.Render()
-		return nil
+		rep.Sections = append(rep.Sections, &report.Section{
+			Name:  "eventType",
+			Title: "Event Type",
+			Rows: []*report.Row{
+				{Key: "ManagementEvent", Label: "Management Event", Value: int64(eventTypeCount["ManagementEvent"])},
+				{Key: "DataEvent", Label: "Data Event", Value: int64(eventTypeCount["DataEvent"])},
+			},
+		})
+		rep.AddSection("eventSource", "Event Source", toInt64(eventSourceCount))
+		rep.AddSection("region", "Region", toInt64(regionCount))
+		rep.AddSection("recipientAccountId", "Recipient Account ID", toInt64(recipientAccountIDCount))
+		return rep.Write(cmd.OutOrStdout(), analyzeFormat)
 	},
 }
 
@@ -144,8 +209,82 @@ func init() {
 	analyzeCmd.Flags().StringVarP(&opt.DatePath, "date", "d", time.Now().Format("2006/01/02"), "target date (eg. 2006/01/02, 2006/01, 2006)")
 	analyzeCmd.Flags().StringVarP(&opt.StartDatePath, "start-date", "s", "", "start date (eg. 2006/01/02)")
 	analyzeCmd.Flags().StringVarP(&opt.EndDatePath, "end-date", "e", "", "end date (eg. 2006/01/02)")
+	analyzeCmd.Flags().StringVarP(&since, "since", "", "", "start time of events. it takes precedence over dates (eg. 2006-01-02T15:04:05Z, 2h, 7d)")
+	analyzeCmd.Flags().StringVarP(&until, "until", "", "", "end time of events. default is now (eg. 2006-01-02T15:04:05+09:00, 30m)")
+	analyzeCmd.Flags().DurationVarP(&opt.DeliveryDelay, "delivery-delay", "", time.Hour, "max delay assumed from events to the delivery of trail log files. trail log files delivered too late for the range of --since and --until (or the days of --date, --start-date and --end-date) are skipped")
 	analyzeCmd.Flags().StringSliceVarP(&opt.Accounts, "account", "a", []string{}, "target account ID")
 	analyzeCmd.Flags().StringSliceVarP(&opt.Regions, "region", "r", []string{}, "target region")
 	analyzeCmd.Flags().BoolVarP(&opt.AllAccounts, "all-accounts", "A", false, "all accounts")
 	analyzeCmd.Flags().BoolVarP(&opt.AllRegions, "all-regions", "R", false, "all regions")
+	analyzeCmd.Flags().StringVarP(&opt.Organization, "organization", "", "", "target organization ID of the organization trail (eg. o-xxxxxxxxxx)")
+	analyzeCmd.Flags().IntVarP(&opt.Concurrency, "concurrency", "c", 16, "max number of concurrent requests to the trail log storage")
+	analyzeCmd.Flags().IntVarP(&opt.MaxRetries, "max-retries", "", 5, "max number of retries to get a trail log file on throttling or server errors (-1 disables retries)")
+	analyzeCmd.Flags().StringVarP(&maxMemory, "max-memory", "", "", "max memory to sort events. events over it are spilled to temporary files (eg. 512MB, 2GB)")
+	analyzeCmd.Flags().StringVarP(&checkpointPath, "checkpoint", "", "", "checkpoint file to record the progress (and the analysis state) and resume from it")
+	analyzeCmd.Flags().StringVarP(&analyzeFormat, "format", "f", "table", "output format (table, json, csv, markdown)")
+	analyzeCmd.Flags().StringArrayVarP(&groupBy, "group-by", "g", []string{}, "count events grouped by the field paths instead of the default breakdowns, sorted by count. fields of pairs are separated by comma (eg. userIdentity.arn, eventSource,eventName)")
+	analyzeCmd.Flags().IntVarP(&top, "top", "n", 0, "show only the top N groups of --group-by (0 shows all)")
+	analyzeCmd.Flags().StringVarP(&bucket, "bucket", "", "", "count events per time bucket as the histogram instead of the default breakdowns (eg. 5m, 1h, 1d)")
+	analyzeCmd.Flags().StringVarP(&splitBy, "split-by", "", "", "split the histogram of --bucket by the field path (eg. eventSource)")
+	analyzeCmd.Flags().StringVarP(&opt.Filter, "filter", "", "", "filter expression for events (eg. 'eventSource == \"iam.amazonaws.com\" && errorCode != \"\"')")
+}
+
+// parseGroupBy parses --group-by into the field paths of each group
+func parseGroupBy() ([][]string, error) {
+	groups := [][]string{}
+	for _, g := range groupBy {
+		fields := []string{}
+		for _, p := range strings.Split(g, ",") {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				return nil, fmt.Errorf("invalid --group-by: %s", g)
+			}
+			fields = append(fields, p)
+		}
+		groups = append(groups, fields)
+	}
+	return groups, nil
+}
+
+// checkGroupByState returns an error if the counts restored from the checkpoint are not for the groups of --group-by
+func checkGroupByState(counts map[string]map[string]int, groups [][]string) error {
+	saved := []string{}
+	for k := range counts {
+		saved = append(saved, k)
+	}
+	current := []string{}
+	for _, g := range groups {
+		current = append(current, strings.Join(g, ","))
+	}
+	sort.Strings(saved)
+	sort.Strings(current)
+	if strings.Join(saved, " ") != strings.Join(current, " ") {
+		return fmt.Errorf("checkpoint file %s is for other --group-by %q, not %q", checkpointPath, saved, current)
+	}
+	return nil
+}
+
+// checkHistogramState returns an error if the histogram restored from the checkpoint is not for --bucket and --split-by
+func checkHistogramState(saved, current *report.Histogram) error {
+	desc := func(h *report.Histogram) string {
+		if h == nil {
+			return "no --bucket"
+		}
+		if h.SplitBy == "" {
+			return fmt.Sprintf("--bucket %s", h.Bucket)
+		}
+		return fmt.Sprintf("--bucket %s --split-by %s", h.Bucket, h.SplitBy)
+	}
+	if desc(saved) != desc(current) {
+		return fmt.Errorf("checkpoint file %s is for %s, not %s", checkpointPath, desc(saved), desc(current))
+	}
+	return nil
+}
+
+func toInt64(m map[string]int) map[string]int64 {
+	c := map[string]int64{}
+	for k, v := range m {
+		c[k] = int64(v)
+	}
+	return c
 }
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// writeTrailLog writes a trail log file with the same layout as the S3 bucket of AWS CloudTrail.
+func writeTrailLog(t *testing.T, root string, delivered time.Time, records ...string) {
+	t.Helper()
+	key := fmt.Sprintf("AWSLogs/123456789012/CloudTrail/ap-northeast-1/%s/123456789012_CloudTrail_ap-northeast-1_%s_%d.json.gz", delivered.Format("2006/01/02"), delivered.Format("20060102T1504Z"), delivered.UnixNano())
+	p := filepath.Join(root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+		t.Fatal(err)
+	}
+	b := new(bytes.Buffer)
+	gw := gzip.NewWriter(b)
+	if _, err := fmt.Fprintf(gw, `{"Records":[%s]}`, strings.Join(records, ",")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, b.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testRecord(id, eventName string, et time.Time) string {
+	return fmt.Sprintf(`{"eventTime":"%s","eventID":"%s","eventSource":"iam.amazonaws.com","eventName":"%s"}`, et.UTC().Format(time.RFC3339Nano), id, eventName)
+}
+
+// run executes the root command with args as the trail-digger CLI does and returns its stdout.
+// The flags are reset to the defaults after it because they are bound to the global variables.
+func run(t *testing.T, args ...string) string {
+	t.Helper()
+	out, err := runE(args...)
+	if err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	return out
+}
+
+func runE(args ...string) (string, error) {
+	defer resetFlags(args)
+	stdout := new(bytes.Buffer)
+	rootCmd.SetOut(stdout)
+	rootCmd.SetErr(new(bytes.Buffer))
+	rootCmd.SetArgs(args)
+	err := rootCmd.Execute()
+	return stdout.String(), err
+}
+
+func resetFlags(args []string) {
+	c, _, err := rootCmd.Find(args)
+	if err != nil {
+		return
+	}
+	c.Flags().VisitAll(func(f *pflag.Flag) {
+		if !f.Changed {
+			return
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			d := []string{}
+			if v := strings.Trim(f.DefValue, "[]"); v != "" {
+				d = strings.Split(v, ",")
+			}
+			_ = sv.Replace(d)
+		} else {
+			_ = f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	})
+	opt.Checkpoint = nil
+	opt.Since = time.Time{}
+	opt.Until = time.Time{}
+}
+
+func TestEventsDefaultFlags(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeTrailLog(t, dir, now, testRecord("a", "ListUsers", now))
+
+	got := run(t, "events", "file://"+dir)
+	if !strings.Contains(got, `"eventID":"a"`) {
+		t.Errorf("got %q, want the event in json", got)
+	}
+}
+
+func TestAnalyzeGroupByShowsAll(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	records := []string{}
+	for i := 0; i < 15; i++ {
+		records = append(records, testRecord(fmt.Sprintf("id%d", i), fmt.Sprintf("Event%d", i), now))
+	}
+	writeTrailLog(t, dir, now, records...)
+
+	got := run(t, "analyze", "file://"+dir, "--group-by", "eventName", "--format", "csv")
+	if n := strings.Count(got, "Event"); n != 15 {
+		t.Errorf("got %d groups, want 15\n%s", n, got)
+	}
+}
+
+func TestEventsResumeOutput(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeTrailLog(t, dir, now, testRecord("a", "ListUsers", now), testRecord("b", "ListRoles", now.Add(time.Second)))
+	cp := filepath.Join(t.TempDir(), "events.checkpoint")
+	out := filepath.Join(t.TempDir(), "events.jsonl")
+
+	// the days already walked are skipped on resume, and the events output by the previous run are kept
+	for i := 0; i < 2; i++ {
+		run(t, "events", "file://"+dir, "--checkpoint", cp, "--output", out)
+		b, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(string(b), "\n"); n != 2 {
+			t.Errorf("run %d: got %d events, want 2", i, n)
+		}
+	}
+}
+
+func TestAnalyzeResumeGroupBy(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeTrailLog(t, dir, now, testRecord("a", "ListUsers", now))
+	cp := filepath.Join(t.TempDir(), "analyze.checkpoint")
+
+	run(t, "analyze", "file://"+dir, "--checkpoint", cp, "--group-by", "eventName")
+	if _, err := runE("analyze", "file://"+dir, "--checkpoint", cp, "--group-by", "eventSource"); err == nil {
+		t.Error("want error for other --group-by")
+	}
+	if _, err := runE("analyze", "file://"+dir, "--checkpoint", cp); err == nil {
+		t.Error("want error without --group-by")
+	}
+	got := run(t, "analyze", "file://"+dir, "--checkpoint", cp, "--group-by", "eventName", "--format", "csv")
+	if !strings.Contains(got, "ListUsers") {
+		t.Errorf("got %q, want the restored counts", got)
+	}
+}
+
+func TestAnalyzeResumeBucket(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeTrailLog(t, dir, now, testRecord("a", "ListUsers", now))
+	cp := filepath.Join(t.TempDir(), "analyze.checkpoint")
+
+	run(t, "analyze", "file://"+dir, "--checkpoint", cp, "--bucket", "1h")
+	for _, args := range [][]string{
+		{"--bucket", "5m"},
+		{"--bucket", "1h", "--split-by", "eventName"},
+		{},
+	} {
+		if _, err := runE(append([]string{"analyze", "file://" + dir, "--checkpoint", cp}, args...)...); err == nil {
+			t.Errorf("%v: want error", args)
+		}
+	}
+	got := run(t, "analyze", "file://"+dir, "--checkpoint", cp, "--bucket", "1h", "--format", "csv")
+	if !strings.Contains(got, ",1\n") {
+		t.Errorf("got %q, want the restored counts", got)
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	defer func() {
+		since, until = "", ""
+		opt.Since, opt.Until = time.Time{}, time.Time{}
+	}()
+	since = "2022-02-03T14:00:00Z"
+	until = ""
+	if err := parseTimeRange(); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2022, 2, 3, 14, 0, 0, 0, time.UTC); !opt.Since.Equal(want) {
+		t.Errorf("got %v want %v", opt.Since, want)
+	}
+	// the current time when events are walked
+	if !opt.Until.IsZero() {
+		t.Errorf("got %v want zero", opt.Until)
+	}
+}
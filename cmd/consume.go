@@ -0,0 +1,69 @@
+/*
+Copyright © 2022 GMO Pepabo, inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pepabo/trail-digger/trail"
+	"github.com/spf13/cobra"
+)
+
+var (
+	endpointURL   string
+	s3EndpointURL string
+)
+
+var consumeCmd = &cobra.Command{
+	Use:   "consume",
+	Short: "show AWS CloudTrail events in trail logs notified via an SQS queue",
+	Long:  `show AWS CloudTrail events in trail logs notified via an SQS queue (eg. sqs://sqs.ap-northeast-1.amazonaws.com/1234567890/queue-name).`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		queueURL, err := trail.QueueURL(args[0], endpointURL)
+		if err != nil {
+			return err
+		}
+		sess, err := session.NewSession()
+		if err != nil {
+			return err
+		}
+		return trail.ConsumeEvents(cmd.Context(), sess, queueURL, s3EndpointURL, opt, func(r *trail.Record) error {
+			b, err := r.MarshalRaw()
+			if err != nil {
+				return err
+			}
+			cmd.Println(string(b))
+			return nil
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(consumeCmd)
+	consumeCmd.Flags().StringSliceVarP(&opt.Accounts, "account", "a", []string{}, "target account ID")
+	consumeCmd.Flags().StringSliceVarP(&opt.Regions, "region", "r", []string{}, "target region")
+	consumeCmd.Flags().StringVarP(&opt.Organization, "organization", "", "", "target organization ID of the organization trail (eg. o-xxxxxxxxxx)")
+	consumeCmd.Flags().IntVarP(&opt.MaxRetries, "max-retries", "", 5, "max number of retries to get a trail log file on throttling or server errors (-1 disables retries)")
+	consumeCmd.Flags().StringVarP(&opt.Filter, "filter", "", "", "filter expression for events (eg. 'eventSource == \"iam.amazonaws.com\" && errorCode != \"\"')")
+	consumeCmd.Flags().StringVarP(&endpointURL, "endpoint-url", "", "", "endpoint URL of a SQS-compatible queue (eg. http://localhost:9324). trail log files are still got from Amazon S3 unless --s3-endpoint-url is specified")
+	consumeCmd.Flags().StringVarP(&s3EndpointURL, "s3-endpoint-url", "", "", "endpoint URL of a S3-compatible storage to get trail log files (eg. http://localhost:9000)")
+}
@@ -0,0 +1,137 @@
+/*
+Copyright © 2022 GMO Pepabo, inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/goccy/go-json"
+	"github.com/olekukonko/tablewriter"
+	"github.com/pepabo/trail-digger/detect"
+	"github.com/pepabo/trail-digger/trail"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rules       []string
+	minSeverity string
+	listRules   bool
+)
+
+var detectCmd = &cobra.Command{
+	Use:   "detect",
+	Short: "detect suspicious AWS CloudTrail events by built-in rules using trail logs",
+	Long:  `detect suspicious AWS CloudTrail events by built-in rules using trail logs.`,
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if listRules {
+			table := tablewriter.NewWriter(cmd.OutOrStdout())
+			table.SetHeader([]string{"ID", "Severity", "Description"})
+			table.SetAutoWrapText(false)
+			table.SetAutoFormatHeaders(false)
+			table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+			table.SetCenterSeparator("")
+			table.SetColumnSeparator("")
+			table.SetRowSeparator("")
+			table.SetHeaderLine(false)
+			table.SetBorder(false)
+			for _, r := range detect.Rules {
+				table.Append([]string{r.ID, r.Severity, r.Description})
+			}
+			table.Render()
+			return nil
+		}
+		if len(args) == 0 {
+			return errors.New("requires the trail log storage (eg. s3://your-trail-log-bucket)")
+		}
+		dsn := args[0]
+		sess, err := session.NewSession()
+		if err != nil {
+			return err
+		}
+		if err := parseTimeRange(); err != nil {
+			return err
+		}
+		if err := loadCheckpoint(dsn); err != nil {
+			return err
+		}
+		if err := parseMaxMemory(); err != nil {
+			return err
+		}
+		d, err := detect.NewDetector(rules, minSeverity)
+		if err != nil {
+			return err
+		}
+		var (
+			mu    sync.Mutex
+			total int
+		)
+		if err := trail.WalkEventsWithContext(cmd.Context(), sess, dsn, opt, func(r *trail.Record) error {
+			findings, err := d.Detect(r)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, f := range findings {
+				b, err := json.Marshal(f)
+				if err != nil {
+					return err
+				}
+				cmd.Println(string(b))
+				total += 1
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		log.Info().Int("findings", total).Msg("Detection completed")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(detectCmd)
+	detectCmd.Flags().StringSliceVarP(&rules, "rule", "", []string{}, "target rule ID (default: all rules)")
+	detectCmd.Flags().StringVarP(&minSeverity, "severity", "", "", "minimum severity of rules (low, medium, high, critical)")
+	detectCmd.Flags().BoolVarP(&listRules, "list-rules", "", false, "list the built-in rules")
+	detectCmd.Flags().StringVarP(&opt.DatePath, "date", "d", time.Now().Format("2006/01/02"), "target date (eg. 2006/01/02, 2006/01, 2006)")
+	detectCmd.Flags().StringVarP(&opt.StartDatePath, "start-date", "s", "", "start date (eg. 2006/01/02)")
+	detectCmd.Flags().StringVarP(&opt.EndDatePath, "end-date", "e", "", "end date (eg. 2006/01/02)")
+	detectCmd.Flags().StringVarP(&since, "since", "", "", "start time of events. it takes precedence over dates (eg. 2006-01-02T15:04:05Z, 2h, 7d)")
+	detectCmd.Flags().StringVarP(&until, "until", "", "", "end time of events. default is now (eg. 2006-01-02T15:04:05+09:00, 30m)")
+	detectCmd.Flags().DurationVarP(&opt.DeliveryDelay, "delivery-delay", "", time.Hour, "max delay assumed from events to the delivery of trail log files. trail log files delivered too late for the range of --since and --until (or the days of --date, --start-date and --end-date) are skipped")
+	detectCmd.Flags().StringSliceVarP(&opt.Accounts, "account", "a", []string{}, "target account ID")
+	detectCmd.Flags().StringSliceVarP(&opt.Regions, "region", "r", []string{}, "target region")
+	detectCmd.Flags().BoolVarP(&opt.AllAccounts, "all-accounts", "A", false, "all accounts")
+	detectCmd.Flags().BoolVarP(&opt.AllRegions, "all-regions", "R", false, "all regions")
+	detectCmd.Flags().StringVarP(&opt.Organization, "organization", "", "", "target organization ID of the organization trail (eg. o-xxxxxxxxxx)")
+	detectCmd.Flags().IntVarP(&opt.Concurrency, "concurrency", "c", 16, "max number of concurrent requests to the trail log storage")
+	detectCmd.Flags().IntVarP(&opt.MaxRetries, "max-retries", "", 5, "max number of retries to get a trail log file on throttling or server errors (-1 disables retries)")
+	detectCmd.Flags().StringVarP(&maxMemory, "max-memory", "", "", "max memory to sort events. events over it are spilled to temporary files (eg. 512MB, 2GB)")
+	detectCmd.Flags().StringVarP(&checkpointPath, "checkpoint", "", "", "checkpoint file to record the progress and resume from it")
+	detectCmd.Flags().StringVarP(&opt.Filter, "filter", "", "", "filter expression for events (eg. 'eventSource == \"iam.amazonaws.com\" && errorCode != \"\"')")
+}
@@ -22,10 +22,11 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"errors"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/goccy/go-json"
+	"github.com/pepabo/trail-digger/sink"
 	"github.com/pepabo/trail-digger/trail"
 	"github.com/spf13/cobra"
 )
@@ -41,17 +42,42 @@ var eventsCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		if err := trail.WalkEvents(sess, dsn, opt, func(r *trail.Record) error {
-			b, err := json.Marshal(r)
-			if err != nil {
-				return err
-			}
-			cmd.Println(string(b))
-			return nil
-		}); err != nil {
+		if err := parseTimeRange(); err != nil {
 			return err
 		}
-		return nil
+		// A parquet file is not readable until the footer is written on close, so it can not be resumed
+		if checkpointPath != "" && eventsFormat == "parquet" {
+			return errors.New("--checkpoint can not be used with --format parquet")
+		}
+		if err := loadCheckpoint(dsn); err != nil {
+			return err
+		}
+		if err := parseMaxMemory(); err != nil {
+			return err
+		}
+		out, err := sink.New(cmd.Context(), sink.Option{
+			Format:     eventsFormat,
+			Output:     output,
+			Columns:    columns,
+			Stdout:     cmd.OutOrStdout(),
+			BatchSize:  batchSize,
+			Workers:    workers,
+			Headers:    headers,
+			Gzip:       gzipBody,
+			DeadLetter: deadLetter,
+			Append:     opt.Checkpoint != nil && opt.Checkpoint.Resumed(),
+		})
+		if err != nil {
+			return err
+		}
+		if opt.Checkpoint != nil {
+			opt.Checkpoint.SetFlushFunc(out.Flush)
+		}
+		if err := trail.WalkEventsWithContext(cmd.Context(), sess, dsn, opt, out.Write); err != nil {
+			_ = out.Close()
+			return err
+		}
+		return out.Close()
 	},
 }
 
@@ -60,8 +86,25 @@ func init() {
 	eventsCmd.Flags().StringVarP(&opt.DatePath, "date", "d", time.Now().Format("2006/01/02"), "target date (eg. 2006/01/02, 2006/01, 2006)")
 	eventsCmd.Flags().StringVarP(&opt.StartDatePath, "start-date", "s", "", "start date (eg. 2006/01/02)")
 	eventsCmd.Flags().StringVarP(&opt.EndDatePath, "end-date", "e", "", "end date (eg. 2006/01/02)")
+	eventsCmd.Flags().StringVarP(&since, "since", "", "", "start time of events. it takes precedence over dates (eg. 2006-01-02T15:04:05Z, 2h, 7d)")
+	eventsCmd.Flags().StringVarP(&until, "until", "", "", "end time of events. default is now (eg. 2006-01-02T15:04:05+09:00, 30m)")
+	eventsCmd.Flags().DurationVarP(&opt.DeliveryDelay, "delivery-delay", "", time.Hour, "max delay assumed from events to the delivery of trail log files. trail log files delivered too late for the range of --since and --until (or the days of --date, --start-date and --end-date) are skipped")
 	eventsCmd.Flags().StringSliceVarP(&opt.Accounts, "account", "a", []string{}, "target account ID")
 	eventsCmd.Flags().StringSliceVarP(&opt.Regions, "region", "r", []string{}, "target region")
 	eventsCmd.Flags().BoolVarP(&opt.AllAccounts, "all-accounts", "A", false, "all accounts")
 	eventsCmd.Flags().BoolVarP(&opt.AllRegions, "all-regions", "R", false, "all regions")
+	eventsCmd.Flags().StringVarP(&opt.Organization, "organization", "", "", "target organization ID of the organization trail (eg. o-xxxxxxxxxx)")
+	eventsCmd.Flags().IntVarP(&opt.Concurrency, "concurrency", "c", 16, "max number of concurrent requests to the trail log storage")
+	eventsCmd.Flags().IntVarP(&opt.MaxRetries, "max-retries", "", 5, "max number of retries to get a trail log file on throttling or server errors (-1 disables retries)")
+	eventsCmd.Flags().StringVarP(&maxMemory, "max-memory", "", "", "max memory to sort events. events over it are spilled to temporary files (eg. 512MB, 2GB)")
+	eventsCmd.Flags().StringVarP(&checkpointPath, "checkpoint", "", "", "checkpoint file to record the progress and resume from it")
+	eventsCmd.Flags().StringVarP(&opt.Filter, "filter", "", "", "filter expression for events (eg. 'eventSource == \"iam.amazonaws.com\" && errorCode != \"\"')")
+	eventsCmd.Flags().StringVarP(&eventsFormat, "format", "f", "json", "output format (json, csv, tsv, parquet, sqlite)")
+	eventsCmd.Flags().StringVarP(&output, "output", "o", "", "output file (required for parquet and sqlite) or URL (eg. es://localhost:9200/cloudtrail, splunk+https://token@localhost:8088, https://example.com/webhook). for parquet, a directory (or a path ending with /) is partitioned by date, account and region")
+	eventsCmd.Flags().IntVarP(&batchSize, "batch-size", "", 500, "number of events sent in a request to the output URL")
+	eventsCmd.Flags().IntVarP(&workers, "workers", "", 4, "number of concurrent requests to the output URL")
+	eventsCmd.Flags().StringArrayVarP(&headers, "header", "H", []string{}, "additional header of requests to the output URL (eg. 'Authorization: Splunk <token>')")
+	eventsCmd.Flags().BoolVarP(&gzipBody, "gzip", "", false, "compress request bodies to the output URL with gzip")
+	eventsCmd.Flags().StringVarP(&deadLetter, "dead-letter", "", "", "file to append the request bodies to the output URL which still fail after retries")
+	eventsCmd.Flags().StringSliceVarP(&columns, "columns", "", sink.DefaultColumns, "columns of csv and tsv in dotted paths of event fields (eg. userIdentity.arn)")
 }
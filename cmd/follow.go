@@ -0,0 +1,67 @@
+/*
+Copyright © 2022 GMO Pepabo, inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pepabo/trail-digger/trail"
+	"github.com/spf13/cobra"
+)
+
+var interval time.Duration
+
+var followCmd = &cobra.Command{
+	Use:   "follow",
+	Short: "follow AWS CloudTrail events in newly delivered trail logs",
+	Long:  `follow AWS CloudTrail events in newly delivered trail logs.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dsn := args[0]
+		sess, err := session.NewSession()
+		if err != nil {
+			return err
+		}
+		return trail.FollowEvents(cmd.Context(), sess, dsn, opt, interval, func(r *trail.Record) error {
+			b, err := r.MarshalRaw()
+			if err != nil {
+				return err
+			}
+			cmd.Println(string(b))
+			return nil
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(followCmd)
+	followCmd.Flags().StringSliceVarP(&opt.Accounts, "account", "a", []string{}, "target account ID")
+	followCmd.Flags().StringSliceVarP(&opt.Regions, "region", "r", []string{}, "target region")
+	followCmd.Flags().BoolVarP(&opt.AllAccounts, "all-accounts", "A", false, "all accounts")
+	followCmd.Flags().BoolVarP(&opt.AllRegions, "all-regions", "R", false, "all regions")
+	followCmd.Flags().StringVarP(&opt.Organization, "organization", "", "", "target organization ID of the organization trail (eg. o-xxxxxxxxxx)")
+	followCmd.Flags().IntVarP(&opt.Concurrency, "concurrency", "c", 16, "max number of concurrent requests to the trail log storage")
+	followCmd.Flags().IntVarP(&opt.MaxRetries, "max-retries", "", 5, "max number of retries to get a trail log file on throttling or server errors (-1 disables retries)")
+	followCmd.Flags().StringVarP(&opt.Filter, "filter", "", "", "filter expression for events (eg. 'eventSource == \"iam.amazonaws.com\" && errorCode != \"\"')")
+	followCmd.Flags().DurationVarP(&interval, "interval", "i", time.Minute, "interval to poll trail logs")
+}
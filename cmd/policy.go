@@ -0,0 +1,105 @@
+/*
+Copyright © 2022 GMO Pepabo, inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/goccy/go-json"
+	"github.com/pepabo/trail-digger/policy"
+	"github.com/pepabo/trail-digger/trail"
+	"github.com/spf13/cobra"
+)
+
+var (
+	principal  string
+	generalize string
+)
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "generate IAM policy from the actions called by the principal using trail logs",
+	Long:  `generate IAM policy from the actions called by the principal using trail logs.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dsn := args[0]
+		if principal == "" {
+			return errors.New("--principal is required")
+		}
+		sess, err := session.NewSession()
+		if err != nil {
+			return err
+		}
+		if err := parseTimeRange(); err != nil {
+			return err
+		}
+		if err := parseMaxMemory(); err != nil {
+			return err
+		}
+		g, err := policy.NewGenerator(generalize)
+		if err != nil {
+			return err
+		}
+		var mu sync.Mutex
+		if err := trail.WalkEventsWithContext(cmd.Context(), sess, dsn, opt, func(r *trail.Record) error {
+			if !trail.MatchPrincipal(r, principal) {
+				return nil
+			}
+			mu.Lock()
+			g.Add(r)
+			mu.Unlock()
+			return nil
+		}); err != nil {
+			return err
+		}
+		b, err := json.MarshalIndent(g.Policy(), "", "  ")
+		if err != nil {
+			return err
+		}
+		cmd.Println(string(b))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(policyCmd)
+	policyCmd.Flags().StringVarP(&principal, "principal", "p", "", "ARN of the IAM user, the role or the role session, the access key ID or the principal ID")
+	policyCmd.Flags().StringVarP(&generalize, "generalize", "", policy.GeneralizeNone, "generalize resource ARNs with wildcards (none, path, resource)")
+	policyCmd.Flags().StringVarP(&opt.DatePath, "date", "d", time.Now().Format("2006/01/02"), "target date (eg. 2006/01/02, 2006/01, 2006)")
+	policyCmd.Flags().StringVarP(&opt.StartDatePath, "start-date", "s", "", "start date (eg. 2006/01/02)")
+	policyCmd.Flags().StringVarP(&opt.EndDatePath, "end-date", "e", "", "end date (eg. 2006/01/02)")
+	policyCmd.Flags().StringVarP(&since, "since", "", "", "start time of events. it takes precedence over dates (eg. 2006-01-02T15:04:05Z, 2h, 7d)")
+	policyCmd.Flags().StringVarP(&until, "until", "", "", "end time of events. default is now (eg. 2006-01-02T15:04:05+09:00, 30m)")
+	policyCmd.Flags().DurationVarP(&opt.DeliveryDelay, "delivery-delay", "", time.Hour, "max delay assumed from events to the delivery of trail log files. trail log files delivered too late for the range of --since and --until (or the days of --date, --start-date and --end-date) are skipped")
+	policyCmd.Flags().StringSliceVarP(&opt.Accounts, "account", "a", []string{}, "target account ID")
+	policyCmd.Flags().StringSliceVarP(&opt.Regions, "region", "r", []string{}, "target region")
+	policyCmd.Flags().BoolVarP(&opt.AllAccounts, "all-accounts", "A", false, "all accounts")
+	policyCmd.Flags().BoolVarP(&opt.AllRegions, "all-regions", "R", false, "all regions")
+	policyCmd.Flags().StringVarP(&opt.Organization, "organization", "", "", "target organization ID of the organization trail (eg. o-xxxxxxxxxx)")
+	policyCmd.Flags().IntVarP(&opt.Concurrency, "concurrency", "c", 16, "max number of concurrent requests to the trail log storage")
+	policyCmd.Flags().IntVarP(&opt.MaxRetries, "max-retries", "", 5, "max number of retries to get a trail log file on throttling or server errors (-1 disables retries)")
+	policyCmd.Flags().StringVarP(&maxMemory, "max-memory", "", "", "max memory to sort events. events over it are spilled to temporary files (eg. 512MB, 2GB)")
+	policyCmd.Flags().StringVarP(&opt.Filter, "filter", "", "", "filter expression for events (eg. 'eventSource == \"iam.amazonaws.com\" && errorCode != \"\"')")
+}
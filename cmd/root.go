@@ -22,9 +22,16 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/docker/go-units"
+	"github.com/pepabo/trail-digger/report"
 	"github.com/pepabo/trail-digger/trail"
 	"github.com/pepabo/trail-digger/version"
 	"github.com/rs/zerolog"
@@ -33,7 +40,27 @@ import (
 )
 
 var (
-	opt trail.Option
+	opt            trail.Option
+	checkpointPath string
+	maxMemory      string
+	since          string
+	until          string
+	eventsFormat   string
+	analyzeFormat  string
+	sizeFormat     string
+	whoisFormat    string
+	columns        []string
+	output         string
+	batchSize      int
+	workers        int
+	headers        []string
+	gzipBody       bool
+	deadLetter     string
+	groupBy        []string
+	top            int
+	whoisTop       int
+	bucket         string
+	splitBy        string
 )
 
 var rootCmd = &cobra.Command{
@@ -44,12 +71,78 @@ var rootCmd = &cobra.Command{
 	Version:      version.Version,
 }
 
+// loadCheckpoint loads the checkpoint file specified by --checkpoint into opt
+func loadCheckpoint(dsn string) error {
+	if checkpointPath == "" {
+		return nil
+	}
+	cp, err := trail.LoadCheckpoint(checkpointPath, dsn, opt)
+	if err != nil {
+		return err
+	}
+	opt.Checkpoint = cp
+	return nil
+}
+
+// parseMaxMemory parses the size specified by --max-memory (eg. 512MB, 2GB) into opt
+func parseMaxMemory() error {
+	if maxMemory == "" {
+		return nil
+	}
+	n, err := units.RAMInBytes(maxMemory)
+	if err != nil {
+		return fmt.Errorf("invalid max memory: %s", maxMemory)
+	}
+	opt.MaxMemory = n
+	return nil
+}
+
+// parseTimeRange parses the times specified by --since and --until into opt
+func parseTimeRange() error {
+	now := time.Now()
+	if since == "" {
+		if until != "" {
+			return errors.New("--until requires --since")
+		}
+		return nil
+	}
+	st, err := trail.ParseTime(since, now)
+	if err != nil {
+		return err
+	}
+	opt.Since = st
+	// Until is left zero without --until to be the current time when events are walked,
+	// so that the options of a checkpoint with the same --since are kept on resume
+	if until == "" {
+		return nil
+	}
+	et, err := trail.ParseTime(until, now)
+	if err != nil {
+		return err
+	}
+	opt.Until = et
+	return nil
+}
+
+// validateReportFormat validates --format of analyze and size before walking trail logs
+func validateReportFormat(format string) error {
+	for _, f := range report.Formats {
+		if format == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported format: %s", format)
+}
+
 func Execute() {
 	rootCmd.SetOut(os.Stdout)
 	rootCmd.SetErr(os.Stderr)
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
 
-	if err := rootCmd.Execute(); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
 		os.Exit(1)
 	}
 }
@@ -23,22 +23,18 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"regexp"
-	"sort"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/docker/go-units"
-	"github.com/olekukonko/tablewriter"
+	"github.com/pepabo/trail-digger/report"
 	"github.com/pepabo/trail-digger/trail"
+	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
 
-var keyRe = regexp.MustCompile(`/([0-9]+)/CloudTrail/([a-z\-]+\-[123])/`)
-
 var sizeCmd = &cobra.Command{
 	Use:   "size",
 	Short: "show size of trail logs",
@@ -50,69 +46,51 @@ var sizeCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		if err := parseTimeRange(); err != nil {
+			return err
+		}
+		if err := validateReportFormat(sizeFormat); err != nil {
+			return err
+		}
 		size := int64(0)
 		regionCount := map[string]int64{}
 		accountIDCount := map[string]int64{}
+		organizationCount := map[string]int64{}
 		var mu sync.Mutex
-		if err := trail.WalkObjects(sess, dsn, opt, func(o *s3.Object) error {
-			matches := keyRe.FindAllStringSubmatch(*o.Key, 1)
+		if err := trail.WalkObjectsWithContext(cmd.Context(), sess, dsn, opt, func(o *s3.Object) error {
+			k, err := trail.ParseObjectKey(*o.Key)
+			if err != nil {
+				log.Warn().Err(err).Msg("Skip the object")
+				return nil
+			}
 			mu.Lock()
 			size += *o.Size
-			regionCount[matches[0][2]] += *o.Size
-			accountIDCount[matches[0][1]] += *o.Size
+			regionCount[k.Region] += *o.Size
+			accountIDCount[k.AccountID] += *o.Size
+			if k.Organization != "" {
+				organizationCount[k.Organization] += *o.Size
+			}
 			mu.Unlock()
 			return nil
 		}); err != nil {
 			return err
 		}
 
-		data := [][]string{}
-		data = append(data, []string{"", "", ""})
-
-		{
-			// Region
-			keys := []string{}
-			for key := range regionCount {
-				keys = append(keys, key)
-			}
-			sort.Strings(keys)
-			for _, key := range keys {
-				data = append(data, []string{"Region", fmt.Sprintf("%s:", key), fmt.Sprintf("%s (%dB)", units.BytesSize(float64(regionCount[key])), regionCount[key])})
-			}
-			data = append(data, []string{"", "", ""})
+		rep := report.New("bytes", "Size")
+		rep.FormatValue = func(v int64) string {
+			return fmt.Sprintf("%s (%dB)", units.BytesSize(float64(v)), v)
 		}
-
-		{
-			// AccountID
-			keys := []string{}
-			for key := range accountIDCount {
-				keys = append(keys, key)
-			}
-			sort.Strings(keys)
-			for _, key := range keys {
-				data = append(data, []string{"Account ID", fmt.Sprintf("%s:", key), fmt.Sprintf("%s (%dB)", units.BytesSize(float64(accountIDCount[key])), accountIDCount[key])})
-			}
-			data = append(data, []string{"", "", ""})
+		rep.AddSection("region", "Region", regionCount)
+		rep.AddSection("accountId", "Account ID", accountIDCount)
+		if len(organizationCount) > 0 {
+			rep.AddSection("organization", "Organization", organizationCount)
 		}
-
-		data = append(data, []string{"Total", "", fmt.Sprintf("%s (%dB)", units.BytesSize(float64(size)), size)})
-
-		cmd.Println("")
-		table := tablewriter.NewWriter(os.Stdout)
-		table.SetHeader([]string{"", "", "Size"})
-		table.SetAutoWrapText(false)
-		table.SetAutoFormatHeaders(false)
-		table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
-		table.SetColumnAlignment([]int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_RIGHT})
-		table.SetCenterSeparator("")
-		table.SetColumnSeparator("")
-		table.SetRowSeparator("")
-		table.SetHeaderLine(false)
-		table.SetBorder(false)
-		table.SetAutoMergeCellsByColumnIndex([]int{0})
-		table.AppendBulk(data)
-		table.Render()
-		return nil
+		rep.Sections = append(rep.Sections, &report.Section{
+			Name:  "total",
+			Title: "Total",
+			Rows:  []*report.Row{{Key: "", Value: size}},
+		})
+		return rep.Write(cmd.OutOrStdout(), sizeFormat)
 	},
 }
 
@@ -121,8 +99,14 @@ func init() {
 	sizeCmd.Flags().StringVarP(&opt.DatePath, "date", "d", time.Now().Format("2006/01/02"), "target date (eg. 2006/01/02, 2006/01, 2006)")
 	sizeCmd.Flags().StringVarP(&opt.StartDatePath, "start-date", "s", "", "start date (eg. 2006/01/02)")
 	sizeCmd.Flags().StringVarP(&opt.EndDatePath, "end-date", "e", "", "end date (eg. 2006/01/02)")
+	sizeCmd.Flags().StringVarP(&since, "since", "", "", "start time of events. it takes precedence over dates (eg. 2006-01-02T15:04:05Z, 2h, 7d)")
+	sizeCmd.Flags().StringVarP(&until, "until", "", "", "end time of events. default is now (eg. 2006-01-02T15:04:05+09:00, 30m)")
+	sizeCmd.Flags().DurationVarP(&opt.DeliveryDelay, "delivery-delay", "", time.Hour, "max delay assumed from events to the delivery of trail log files. trail log files delivered too late for the range of --since and --until are skipped")
 	sizeCmd.Flags().StringSliceVarP(&opt.Accounts, "account", "a", []string{}, "target account ID")
 	sizeCmd.Flags().StringSliceVarP(&opt.Regions, "region", "r", []string{}, "target region")
 	sizeCmd.Flags().BoolVarP(&opt.AllAccounts, "all-accounts", "A", false, "all accounts")
 	sizeCmd.Flags().BoolVarP(&opt.AllRegions, "all-regions", "R", false, "all regions")
+	sizeCmd.Flags().StringVarP(&opt.Organization, "organization", "", "", "target organization ID of the organization trail (eg. o-xxxxxxxxxx)")
+	sizeCmd.Flags().IntVarP(&opt.Concurrency, "concurrency", "c", 16, "max number of concurrent requests to the trail log storage")
+	sizeCmd.Flags().StringVarP(&sizeFormat, "format", "f", "table", "output format (table, json, csv, markdown)")
 }
@@ -0,0 +1,158 @@
+/*
+Copyright © 2022 GMO Pepabo, inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/olekukonko/tablewriter"
+	"github.com/pepabo/trail-digger/trail"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var publicKeys []string
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "validate the integrity of trail logs using digest files",
+	Long:  `validate the integrity of trail logs using digest files.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dsn := args[0]
+		sess, err := session.NewSession()
+		if err != nil {
+			return err
+		}
+		keys, err := trail.LoadPublicKeys(publicKeys)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			log.Warn().Msg("The signatures of digest files are not verified because no public key is specified")
+		}
+
+		type group struct {
+			account string
+			region  string
+			day     string
+		}
+		counts := map[group]map[string]int{}
+		problems := []*trail.ValidationResult{}
+		var mu sync.Mutex
+		if err := trail.ValidateLogFilesWithContext(cmd.Context(), sess, dsn, opt, keys, func(r *trail.ValidationResult) error {
+			mu.Lock()
+			defer mu.Unlock()
+			g := group{account: r.AccountID, region: r.Region, day: r.Day.Format("2006/01/02")}
+			if _, ok := counts[g]; !ok {
+				counts[g] = map[string]int{}
+			}
+			counts[g][r.Status] += 1
+			switch r.Status {
+			case trail.ValidationStatusValid, trail.ValidationStatusUnverifiedDigest:
+			default:
+				problems = append(problems, r)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		groups := []group{}
+		for g := range counts {
+			groups = append(groups, g)
+		}
+		sort.Slice(groups, func(i, j int) bool {
+			if groups[i].account != groups[j].account {
+				return groups[i].account < groups[j].account
+			}
+			if groups[i].region != groups[j].region {
+				return groups[i].region < groups[j].region
+			}
+			return groups[i].day < groups[j].day
+		})
+		statuses := []string{
+			trail.ValidationStatusValid,
+			trail.ValidationStatusMissing,
+			trail.ValidationStatusModified,
+			trail.ValidationStatusExtra,
+			trail.ValidationStatusInvalidDigest,
+			trail.ValidationStatusUnverifiedDigest,
+		}
+		data := [][]string{}
+		for _, g := range groups {
+			row := []string{g.account, g.region, g.day}
+			for _, s := range statuses {
+				row = append(row, strconv.Itoa(counts[g][s]))
+			}
+			data = append(data, row)
+		}
+
+		cmd.Println("")
+		table := tablewriter.NewWriter(cmd.OutOrStdout())
+		table.SetHeader(append([]string{"Account ID", "Region", "Day"}, statuses...))
+		table.SetAutoWrapText(false)
+		table.SetAutoFormatHeaders(false)
+		table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+		table.SetColumnAlignment([]int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT})
+		table.SetCenterSeparator("")
+		table.SetColumnSeparator("")
+		table.SetRowSeparator("")
+		table.SetHeaderLine(false)
+		table.SetBorder(false)
+		table.SetAutoMergeCellsByColumnIndex([]int{0, 1})
+		table.AppendBulk(data)
+		table.Render()
+
+		if len(problems) == 0 {
+			return nil
+		}
+		sort.Slice(problems, func(i, j int) bool {
+			return problems[i].Key < problems[j].Key
+		})
+		cmd.Println("")
+		for _, p := range problems {
+			cmd.Printf("%s\t%s\t%s\n", p.Status, p.Key, p.Message)
+		}
+		return fmt.Errorf("%d problems found in trail logs", len(problems))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+	validateCmd.Flags().StringVarP(&opt.DatePath, "date", "d", time.Now().Format("2006/01/02"), "target date (eg. 2006/01/02, 2006/01, 2006)")
+	validateCmd.Flags().StringVarP(&opt.StartDatePath, "start-date", "s", "", "start date (eg. 2006/01/02)")
+	validateCmd.Flags().StringVarP(&opt.EndDatePath, "end-date", "e", "", "end date (eg. 2006/01/02)")
+	validateCmd.Flags().StringSliceVarP(&opt.Accounts, "account", "a", []string{}, "target account ID")
+	validateCmd.Flags().StringSliceVarP(&opt.Regions, "region", "r", []string{}, "target region")
+	validateCmd.Flags().BoolVarP(&opt.AllAccounts, "all-accounts", "A", false, "all accounts")
+	validateCmd.Flags().BoolVarP(&opt.AllRegions, "all-regions", "R", false, "all regions")
+	validateCmd.Flags().StringVarP(&opt.Organization, "organization", "", "", "target organization ID of the organization trail (eg. o-xxxxxxxxxx)")
+	validateCmd.Flags().IntVarP(&opt.Concurrency, "concurrency", "c", 16, "max number of concurrent requests to the trail log storage")
+	validateCmd.Flags().IntVarP(&opt.MaxRetries, "max-retries", "", 5, "max number of retries to get a trail log file on throttling or server errors (-1 disables retries)")
+	validateCmd.Flags().StringSliceVarP(&publicKeys, "public-key", "k", []string{}, "public key file (PEM) of AWS CloudTrail to verify the signatures of digest files")
+}
@@ -0,0 +1,92 @@
+/*
+Copyright © 2022 GMO Pepabo, inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pepabo/trail-digger/report"
+	"github.com/pepabo/trail-digger/trail"
+	"github.com/spf13/cobra"
+)
+
+var whoisCmd = &cobra.Command{
+	Use:   "whois [DSN] [ARN|ACCESS_KEY_ID|PRINCIPAL_ID]",
+	Short: "summarize the activity of the IAM user, role, role session or access key using trail logs",
+	Long:  `summarize the activity of the IAM user, role, role session or access key using trail logs.`,
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dsn := args[0]
+		principal := args[1]
+		sess, err := session.NewSession()
+		if err != nil {
+			return err
+		}
+		if err := parseTimeRange(); err != nil {
+			return err
+		}
+		if err := parseMaxMemory(); err != nil {
+			return err
+		}
+		if whoisFormat != "table" && whoisFormat != "json" {
+			return fmt.Errorf("unsupported format: %s", whoisFormat)
+		}
+		wi := report.NewWhois(principal, whoisTop)
+		var mu sync.Mutex
+		if err := trail.WalkEventsWithContext(cmd.Context(), sess, dsn, opt, func(r *trail.Record) error {
+			if !trail.MatchPrincipal(r, principal) {
+				return nil
+			}
+			mu.Lock()
+			wi.Add(r)
+			mu.Unlock()
+			return nil
+		}); err != nil {
+			return err
+		}
+		return wi.Write(cmd.OutOrStdout(), whoisFormat)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(whoisCmd)
+	whoisCmd.Flags().StringVarP(&opt.DatePath, "date", "d", time.Now().Format("2006/01/02"), "target date (eg. 2006/01/02, 2006/01, 2006)")
+	whoisCmd.Flags().StringVarP(&opt.StartDatePath, "start-date", "s", "", "start date (eg. 2006/01/02)")
+	whoisCmd.Flags().StringVarP(&opt.EndDatePath, "end-date", "e", "", "end date (eg. 2006/01/02)")
+	whoisCmd.Flags().StringVarP(&since, "since", "", "", "start time of events. it takes precedence over dates (eg. 2006-01-02T15:04:05Z, 2h, 7d)")
+	whoisCmd.Flags().StringVarP(&until, "until", "", "", "end time of events. default is now (eg. 2006-01-02T15:04:05+09:00, 30m)")
+	whoisCmd.Flags().DurationVarP(&opt.DeliveryDelay, "delivery-delay", "", time.Hour, "max delay assumed from events to the delivery of trail log files. trail log files delivered too late for the range of --since and --until (or the days of --date, --start-date and --end-date) are skipped")
+	whoisCmd.Flags().StringSliceVarP(&opt.Accounts, "account", "a", []string{}, "target account ID")
+	whoisCmd.Flags().StringSliceVarP(&opt.Regions, "region", "r", []string{}, "target region")
+	whoisCmd.Flags().BoolVarP(&opt.AllAccounts, "all-accounts", "A", false, "all accounts")
+	whoisCmd.Flags().BoolVarP(&opt.AllRegions, "all-regions", "R", false, "all regions")
+	whoisCmd.Flags().StringVarP(&opt.Organization, "organization", "", "", "target organization ID of the organization trail (eg. o-xxxxxxxxxx)")
+	whoisCmd.Flags().IntVarP(&opt.Concurrency, "concurrency", "c", 16, "max number of concurrent requests to the trail log storage")
+	whoisCmd.Flags().IntVarP(&opt.MaxRetries, "max-retries", "", 5, "max number of retries to get a trail log file on throttling or server errors (-1 disables retries)")
+	whoisCmd.Flags().StringVarP(&maxMemory, "max-memory", "", "", "max memory to sort events. events over it are spilled to temporary files (eg. 512MB, 2GB)")
+	whoisCmd.Flags().StringVarP(&opt.Filter, "filter", "", "", "filter expression for events (eg. 'eventSource == \"iam.amazonaws.com\" && errorCode != \"\"')")
+	whoisCmd.Flags().StringVarP(&whoisFormat, "format", "f", "table", "output format (table, json)")
+	whoisCmd.Flags().IntVarP(&whoisTop, "top", "n", 10, "show only the top N of each count (0 shows all)")
+}
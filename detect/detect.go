@@ -0,0 +1,271 @@
+package detect
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/pepabo/trail-digger/trail"
+)
+
+// Severities of rules in ascending order
+const (
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+var severityLevels = map[string]int{
+	SeverityLow:      1,
+	SeverityMedium:   2,
+	SeverityHigh:     3,
+	SeverityCritical: 4,
+}
+
+// Rule is the built-in detection rule.
+// An event is detected if it matches Filter (and Match if it is not nil).
+type Rule struct {
+	ID          string
+	Title       string
+	Severity    string
+	Description string
+	// Filter is the filter expression (see trail.Filter)
+	Filter string
+	// Match is the additional condition which can not be written as the filter expression
+	Match func(fields map[string]interface{}) bool
+}
+
+// Rules are the built-in detection rules
+var Rules = []*Rule{
+	{
+		ID:          "root-account-usage",
+		Title:       "Root account usage",
+		Severity:    SeverityHigh,
+		Description: "The root user of the account called the API or signed in to the console",
+		Filter:      `userIdentity.type == "Root" && userIdentity.invokedBy == "" && eventType != "AwsServiceEvent"`,
+	},
+	{
+		ID:          "console-login-without-mfa",
+		Title:       "Console login without MFA",
+		Severity:    SeverityMedium,
+		Description: "The IAM user signed in to the console without MFA",
+		Filter:      `eventName == "ConsoleLogin" && userIdentity.type == "IAMUser" && responseElements.ConsoleLogin == "Success" && additionalEventData.MFAUsed != "Yes"`,
+	},
+	{
+		ID:          "cloudtrail-logging-stopped",
+		Title:       "CloudTrail logging stopped",
+		Severity:    SeverityCritical,
+		Description: "The logging of the trail was stopped or the trail was deleted",
+		Filter:      `eventSource == "cloudtrail.amazonaws.com" && eventName =~ "^(StopLogging|DeleteTrail)$" && errorCode == ""`,
+	},
+	{
+		ID:          "security-group-open-to-world",
+		Title:       "Security group opened to the world",
+		Severity:    SeverityHigh,
+		Description: "The ingress rule from 0.0.0.0/0 or ::/0 was added to the security group",
+		Filter:      `eventSource == "ec2.amazonaws.com" && eventName == "AuthorizeSecurityGroupIngress" && errorCode == ""`,
+		Match:       openToWorld,
+	},
+	{
+		ID:          "access-key-created-for-other-user",
+		Title:       "Access key created for another user",
+		Severity:    SeverityMedium,
+		Description: "The access key was created for the IAM user other than the caller",
+		Filter:      `eventSource == "iam.amazonaws.com" && eventName == "CreateAccessKey" && errorCode == "" && requestParameters.userName != "" && requestParameters.userName != userIdentity.userName`,
+	},
+	{
+		ID:          "bucket-policy-public",
+		Title:       "Bucket made public by bucket policy",
+		Severity:    SeverityCritical,
+		Description: "The bucket policy allowing any principal without conditions was put on the S3 bucket",
+		Filter:      `eventSource == "s3.amazonaws.com" && eventName == "PutBucketPolicy" && errorCode == ""`,
+		Match:       publicBucketPolicy,
+	},
+}
+
+// Finding is the event detected by the rule
+type Finding struct {
+	Rule      string          `json:"rule"`
+	Title     string          `json:"title"`
+	Severity  string          `json:"severity"`
+	EventTime time.Time       `json:"eventTime"`
+	EventID   string          `json:"eventID"`
+	Record    json.RawMessage `json:"record"`
+}
+
+type compiledRule struct {
+	*Rule
+	filter *trail.Filter
+}
+
+// Detector detects events by the rules
+type Detector struct {
+	rules []*compiledRule
+}
+
+// NewDetector returns the Detector with the rules of ids (all rules if it is empty) and the severity at least minSeverity
+func NewDetector(ids []string, minSeverity string) (*Detector, error) {
+	min := 0
+	if minSeverity != "" {
+		l, ok := severityLevels[minSeverity]
+		if !ok {
+			return nil, fmt.Errorf("invalid severity: %s", minSeverity)
+		}
+		min = l
+	}
+	selected := map[string]bool{}
+	for _, id := range ids {
+		found := false
+		for _, r := range Rules {
+			if r.ID == id {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown rule: %s", id)
+		}
+		selected[id] = true
+	}
+	d := &Detector{}
+	for _, r := range Rules {
+		if len(selected) > 0 && !selected[r.ID] {
+			continue
+		}
+		if severityLevels[r.Severity] < min {
+			continue
+		}
+		f, err := trail.CompileFilter(r.Filter)
+		if err != nil {
+			return nil, err
+		}
+		d.rules = append(d.rules, &compiledRule{Rule: r, filter: f})
+	}
+	return d, nil
+}
+
+// Detect returns the findings of the event
+func (d *Detector) Detect(r *trail.Record) ([]*Finding, error) {
+	fields, err := r.Fields()
+	if err != nil {
+		return nil, err
+	}
+	findings := []*Finding{}
+	for _, rule := range d.rules {
+		if !rule.filter.MatchFields(fields) {
+			continue
+		}
+		if rule.Match != nil && !rule.Match(fields) {
+			continue
+		}
+		raw, err := r.MarshalRaw()
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, &Finding{
+			Rule:      rule.ID,
+			Title:     rule.Title,
+			Severity:  rule.Severity,
+			EventTime: r.EventTime,
+			EventID:   r.EventID,
+			Record:    raw,
+		})
+	}
+	return findings, nil
+}
+
+// elements returns the elements of the array, or the value itself if it is not an array
+func elements(v interface{}) []interface{} {
+	switch vv := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return vv
+	default:
+		return []interface{}{v}
+	}
+}
+
+func lookupString(v interface{}, path string) string {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	s, _ := trail.Lookup(m, path)
+	return trail.ValueString(s)
+}
+
+// openToWorld reports whether the ingress rules of AuthorizeSecurityGroupIngress allow 0.0.0.0/0 or ::/0
+func openToWorld(fields map[string]interface{}) bool {
+	if lookupString(fields, "requestParameters.cidrIp") == "0.0.0.0/0" {
+		return true
+	}
+	perms, _ := trail.Lookup(fields, "requestParameters.ipPermissions.items")
+	for _, p := range elements(perms) {
+		m, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		ranges, _ := trail.Lookup(m, "ipRanges.items")
+		for _, r := range elements(ranges) {
+			if lookupString(r, "cidrIp") == "0.0.0.0/0" {
+				return true
+			}
+		}
+		ranges, _ = trail.Lookup(m, "ipv6Ranges.items")
+		for _, r := range elements(ranges) {
+			if lookupString(r, "cidrIpv6") == "::/0" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// publicBucketPolicy reports whether the bucket policy of PutBucketPolicy has the statement allowing any principal without conditions
+func publicBucketPolicy(fields map[string]interface{}) bool {
+	p, _ := trail.Lookup(fields, "requestParameters.bucketPolicy")
+	if s, ok := p.(string); ok {
+		// the policy recorded as a JSON string
+		var v interface{}
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return false
+		}
+		p = v
+	}
+	policy, ok := p.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for _, st := range elements(policy["Statement"]) {
+		m, ok := st.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if trail.ValueString(m["Effect"]) != "Allow" {
+			continue
+		}
+		if c, ok := m["Condition"].(map[string]interface{}); ok && len(c) > 0 {
+			continue
+		}
+		if publicPrincipal(m["Principal"]) {
+			return true
+		}
+	}
+	return false
+}
+
+// publicPrincipal reports whether the principal is "*" or {"AWS": "*"}
+func publicPrincipal(v interface{}) bool {
+	switch p := v.(type) {
+	case string:
+		return p == "*"
+	case map[string]interface{}:
+		for _, a := range elements(p["AWS"]) {
+			if trail.ValueString(a) == "*" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package detect
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pepabo/trail-digger/trail"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name   string
+		record string
+		want   []string
+	}{
+		{
+			"root console login",
+			`{"eventName":"ConsoleLogin","eventType":"AwsConsoleSignIn","userIdentity":{"type":"Root","arn":"arn:aws:iam::123456789012:root"},"responseElements":{"ConsoleLogin":"Success"},"additionalEventData":{"MFAUsed":"No"}}`,
+			[]string{"root-account-usage"},
+		},
+		{
+			"root by AWS service",
+			`{"eventName":"Decrypt","eventType":"AwsServiceEvent","userIdentity":{"type":"Root","invokedBy":"s3.amazonaws.com"}}`,
+			[]string{},
+		},
+		{
+			"console login without MFA",
+			`{"eventName":"ConsoleLogin","userIdentity":{"type":"IAMUser","userName":"alice"},"responseElements":{"ConsoleLogin":"Success"},"additionalEventData":{"MFAUsed":"No"}}`,
+			[]string{"console-login-without-mfa"},
+		},
+		{
+			"console login with MFA",
+			`{"eventName":"ConsoleLogin","userIdentity":{"type":"IAMUser","userName":"alice"},"responseElements":{"ConsoleLogin":"Success"},"additionalEventData":{"MFAUsed":"Yes"}}`,
+			[]string{},
+		},
+		{
+			"stop logging",
+			`{"eventSource":"cloudtrail.amazonaws.com","eventName":"StopLogging","userIdentity":{"type":"IAMUser"}}`,
+			[]string{"cloudtrail-logging-stopped"},
+		},
+		{
+			"delete trail denied",
+			`{"eventSource":"cloudtrail.amazonaws.com","eventName":"DeleteTrail","errorCode":"AccessDenied","userIdentity":{"type":"IAMUser"}}`,
+			[]string{},
+		},
+		{
+			"security group opened to the world",
+			`{"eventSource":"ec2.amazonaws.com","eventName":"AuthorizeSecurityGroupIngress","requestParameters":{"groupId":"sg-1","ipPermissions":{"items":[{"ipProtocol":"tcp","fromPort":22,"toPort":22,"ipRanges":{"items":[{"cidrIp":"192.0.2.0/24"}]}},{"ipProtocol":"tcp","fromPort":22,"toPort":22,"ipv6Ranges":{"items":[{"cidrIpv6":"::/0"}]}}]}}}`,
+			[]string{"security-group-open-to-world"},
+		},
+		{
+			"security group opened to the network",
+			`{"eventSource":"ec2.amazonaws.com","eventName":"AuthorizeSecurityGroupIngress","requestParameters":{"groupId":"sg-1","ipPermissions":{"items":[{"ipProtocol":"tcp","fromPort":22,"toPort":22,"ipRanges":{"items":[{"cidrIp":"192.0.2.0/24"}]}}]}}}`,
+			[]string{},
+		},
+		{
+			"access key created for another user",
+			`{"eventSource":"iam.amazonaws.com","eventName":"CreateAccessKey","userIdentity":{"type":"IAMUser","userName":"alice"},"requestParameters":{"userName":"bob"}}`,
+			[]string{"access-key-created-for-other-user"},
+		},
+		{
+			"access key created for the caller",
+			`{"eventSource":"iam.amazonaws.com","eventName":"CreateAccessKey","userIdentity":{"type":"IAMUser","userName":"alice"},"requestParameters":{"userName":"alice"}}`,
+			[]string{},
+		},
+		{
+			"public bucket policy",
+			`{"eventSource":"s3.amazonaws.com","eventName":"PutBucketPolicy","requestParameters":{"bucketName":"bucket","bucketPolicy":{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["arn:aws:iam::123456789012:root","*"]},"Action":"s3:GetObject","Resource":"arn:aws:s3:::bucket/*"}]}}}`,
+			[]string{"bucket-policy-public"},
+		},
+		{
+			"public bucket policy with conditions",
+			`{"eventSource":"s3.amazonaws.com","eventName":"PutBucketPolicy","requestParameters":{"bucketName":"bucket","bucketPolicy":{"Statement":{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::bucket/*","Condition":{"StringEquals":{"aws:SourceVpce":"vpce-1"}}}}}}`,
+			[]string{},
+		},
+	}
+	d, err := NewDetector(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		r := &trail.Record{}
+		if err := r.UnmarshalJSON([]byte(tt.record)); err != nil {
+			t.Fatal(err)
+		}
+		findings, err := d.Detect(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, f := range findings {
+			got = append(got, f.Rule)
+			if string(f.Record) != tt.record {
+				t.Errorf("%s: got %s want %s", tt.name, f.Record, tt.record)
+			}
+		}
+		if diff := cmp.Diff(got, tt.want, nil); diff != "" {
+			t.Errorf("%s: %s", tt.name, diff)
+		}
+	}
+}
+
+func TestNewDetector(t *testing.T) {
+	tests := []struct {
+		ids         []string
+		minSeverity string
+		want        []string
+		wantErr     bool
+	}{
+		{nil, SeverityCritical, []string{"cloudtrail-logging-stopped", "bucket-policy-public"}, false},
+		{[]string{"root-account-usage", "console-login-without-mfa"}, SeverityHigh, []string{"root-account-usage"}, false},
+		{[]string{"unknown"}, "", nil, true},
+		{nil, "urgent", nil, true},
+	}
+	for _, tt := range tests {
+		d, err := NewDetector(tt.ids, tt.minSeverity)
+		if err != nil {
+			if !tt.wantErr {
+				t.Error(err)
+			}
+			continue
+		}
+		if tt.wantErr {
+			t.Error("want error")
+			continue
+		}
+		got := []string{}
+		for _, r := range d.rules {
+			got = append(got, r.ID)
+		}
+		if diff := cmp.Diff(got, tt.want, nil); diff != "" {
+			t.Errorf("%s", diff)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pepabo/trail-digger/trail"
+)
+
+const (
+	// GeneralizeNone keeps resource ARNs as they are
+	GeneralizeNone = "none"
+	// GeneralizePath replaces the last path segment of resource ARNs with * (eg. arn:aws:s3:::bucket/logs/*)
+	GeneralizePath = "path"
+	// GeneralizeResource replaces the resource ID of resource ARNs with * (eg. arn:aws:s3:::bucket/*, arn:aws:dynamodb:ap-northeast-1:123456789012:table/*)
+	GeneralizeResource = "resource"
+)
+
+// servicePrefixes are the IAM service prefixes different from the event sources
+var servicePrefixes = map[string]string{
+	"models.lex.amazonaws.com": "lex",
+	"monitoring.amazonaws.com": "cloudwatch",
+	"tagging.amazonaws.com":    "tag",
+}
+
+// actionNames are the IAM actions different from the event names
+var actionNames = map[string]string{
+	"lambda:Invoke":                      "lambda:InvokeFunction",
+	"s3:CompleteMultipartUpload":         "s3:PutObject",
+	"s3:CreateMultipartUpload":           "s3:PutObject",
+	"s3:DeleteBucketEncryption":          "s3:PutEncryptionConfiguration",
+	"s3:DeleteBucketLifecycle":           "s3:PutLifecycleConfiguration",
+	"s3:DeleteObjects":                   "s3:DeleteObject",
+	"s3:GetBucketEncryption":             "s3:GetEncryptionConfiguration",
+	"s3:GetBucketLifecycleConfiguration": "s3:GetLifecycleConfiguration",
+	"s3:HeadBucket":                      "s3:ListBucket",
+	"s3:HeadObject":                      "s3:GetObject",
+	"s3:ListBuckets":                     "s3:ListAllMyBuckets",
+	"s3:ListObjectVersions":              "s3:ListBucketVersions",
+	"s3:ListObjects":                     "s3:ListBucket",
+	"s3:ListObjectsV2":                   "s3:ListBucket",
+	"s3:PutBucketEncryption":             "s3:PutEncryptionConfiguration",
+	"s3:PutBucketLifecycleConfiguration": "s3:PutLifecycleConfiguration",
+	"s3:UploadPart":                      "s3:PutObject",
+	"s3:UploadPartCopy":                  "s3:PutObject",
+}
+
+// eventNameVersionRe is the API version suffix of event names (eg. Invoke20150331, GetFunction20150331v2 of AWS Lambda)
+var eventNameVersionRe = regexp.MustCompile(`[0-9]{8}(v[0-9]+)?$`)
+
+// ActionName returns the IAM action name of the event (eg. s3:GetObject for s3.amazonaws.com and GetObject, s3:ListBucket for s3.amazonaws.com and ListObjects)
+func ActionName(eventSource, eventName string) (string, bool) {
+	if eventSource == "" || eventName == "" || !strings.HasSuffix(eventSource, ".amazonaws.com") {
+		return "", false
+	}
+	prefix, ok := servicePrefixes[eventSource]
+	if !ok {
+		prefix = strings.TrimSuffix(eventSource, ".amazonaws.com")
+	}
+	a := fmt.Sprintf("%s:%s", prefix, eventNameVersionRe.ReplaceAllString(eventName, ""))
+	if n, ok := actionNames[a]; ok {
+		return n, true
+	}
+	return a, true
+}
+
+// Statement is the statement of IAM policy
+type Statement struct {
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource []string `json:"Resource"`
+}
+
+// Policy is IAM policy
+type Policy struct {
+	Version   string       `json:"Version"`
+	Statement []*Statement `json:"Statement"`
+}
+
+// Generator generates IAM policy allowing the actions called in the events
+type Generator struct {
+	generalize string
+	// resources are the resources per action. "*" is used for the actions called without resources
+	resources map[string]map[string]bool
+}
+
+// NewGenerator returns the Generator. generalize is GeneralizeNone, GeneralizePath or GeneralizeResource
+func NewGenerator(generalize string) (*Generator, error) {
+	switch generalize {
+	case "", GeneralizeNone, GeneralizePath, GeneralizeResource:
+	default:
+		return nil, fmt.Errorf("invalid generalization: %s", generalize)
+	}
+	return &Generator{generalize: generalize, resources: map[string]map[string]bool{}}, nil
+}
+
+// Add adds the action of the event.
+// The events not authorized by IAM (eg. console sign-in, events of AWS services and access denied) are ignored.
+func (g *Generator) Add(r *trail.Record) {
+	if r.EventType == "AwsConsoleSignIn" || r.EventType == "AwsServiceEvent" {
+		return
+	}
+	if isAccessDenied(r.ErrorCode) {
+		return
+	}
+	a, ok := ActionName(r.EventSource, r.EventName)
+	if !ok {
+		return
+	}
+	if g.resources[a] == nil {
+		g.resources[a] = map[string]bool{}
+	}
+	arns := []string{}
+	object := false
+	for _, res := range r.Resources {
+		if res.Type == "AWS::S3::Object" {
+			object = true
+		}
+	}
+	for _, res := range r.Resources {
+		if res.Arn == "" {
+			continue
+		}
+		// The object-level actions of S3 are allowed on the objects, not the buckets
+		if object && res.Type == "AWS::S3::Bucket" {
+			continue
+		}
+		arns = append(arns, res.Arn)
+	}
+	if len(arns) == 0 {
+		g.resources[a]["*"] = true
+		return
+	}
+	for _, arn := range arns {
+		g.resources[a][Generalize(arn, g.generalize)] = true
+	}
+}
+
+func isAccessDenied(code string) bool {
+	// eg. AccessDenied, Client.UnauthorizedOperation
+	return strings.Contains(code, "AccessDenied") || strings.Contains(code, "Unauthorized")
+}
+
+// Generalize replaces the part of the ARN with * by the generalization
+func Generalize(arn, generalize string) string {
+	splitted := strings.SplitN(arn, ":", 6)
+	if len(splitted) != 6 || splitted[0] != "arn" {
+		return arn
+	}
+	resource := splitted[5]
+	switch generalize {
+	case GeneralizePath:
+		i := strings.LastIndex(resource, "/")
+		if i < 0 {
+			return arn
+		}
+		resource = resource[:i+1] + "*"
+	case GeneralizeResource:
+		// eg. table/name, key/id, bucket/key
+		if i := strings.Index(resource, "/"); i >= 0 {
+			resource = resource[:i+1] + "*"
+		} else if i := strings.Index(resource, ":"); i >= 0 {
+			// eg. function:name, secret:name
+			resource = resource[:i+1] + "*"
+		}
+	default:
+		return arn
+	}
+	splitted[5] = resource
+	return strings.Join(splitted, ":")
+}
+
+// Policy returns IAM policy. The actions allowed on the same resources are grouped into a statement
+func (g *Generator) Policy() *Policy {
+	statements := map[string]*Statement{}
+	for a, rs := range g.resources {
+		resources := []string{}
+		if rs["*"] {
+			resources = []string{"*"}
+		} else {
+			for r := range rs {
+				resources = append(resources, r)
+			}
+			sort.Strings(resources)
+		}
+		k := strings.Join(resources, "\x00")
+		s, ok := statements[k]
+		if !ok {
+			s = &Statement{Effect: "Allow", Resource: resources}
+			statements[k] = s
+		}
+		s.Action = append(s.Action, a)
+	}
+	p := &Policy{Version: "2012-10-17", Statement: []*Statement{}}
+	for _, s := range statements {
+		sort.Strings(s.Action)
+		p.Statement = append(p.Statement, s)
+	}
+	sort.Slice(p.Statement, func(i, j int) bool {
+		return p.Statement[i].Action[0] < p.Statement[j].Action[0]
+	})
+	return p
+}
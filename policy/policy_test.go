@@ -0,0 +1,109 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pepabo/trail-digger/trail"
+)
+
+func TestActionName(t *testing.T) {
+	tests := []struct {
+		eventSource string
+		eventName   string
+		want        string
+		wantOK      bool
+	}{
+		{"s3.amazonaws.com", "GetObject", "s3:GetObject", true},
+		{"monitoring.amazonaws.com", "PutMetricData", "cloudwatch:PutMetricData", true},
+		{"lambda.amazonaws.com", "GetFunction20150331v2", "lambda:GetFunction", true},
+		{"lambda.amazonaws.com", "Invoke20150331", "lambda:InvokeFunction", true},
+		{"s3.amazonaws.com", "ListObjects", "s3:ListBucket", true},
+		{"s3.amazonaws.com", "HeadObject", "s3:GetObject", true},
+		{"", "GetObject", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := ActionName(tt.eventSource, tt.eventName)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("got %v %v want %v %v", got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestGeneralize(t *testing.T) {
+	tests := []struct {
+		arn        string
+		generalize string
+		want       string
+	}{
+		{"arn:aws:s3:::bucket/logs/2022/a.json", GeneralizeNone, "arn:aws:s3:::bucket/logs/2022/a.json"},
+		{"arn:aws:s3:::bucket/logs/2022/a.json", GeneralizePath, "arn:aws:s3:::bucket/logs/2022/*"},
+		{"arn:aws:s3:::bucket/logs/2022/a.json", GeneralizeResource, "arn:aws:s3:::bucket/*"},
+		{"arn:aws:s3:::bucket", GeneralizeResource, "arn:aws:s3:::bucket"},
+		{"arn:aws:dynamodb:ap-northeast-1:123456789012:table/users", GeneralizeResource, "arn:aws:dynamodb:ap-northeast-1:123456789012:table/*"},
+		{"arn:aws:lambda:ap-northeast-1:123456789012:function:name", GeneralizeResource, "arn:aws:lambda:ap-northeast-1:123456789012:function:*"},
+		{"arn:aws:lambda:ap-northeast-1:123456789012:function:name", GeneralizePath, "arn:aws:lambda:ap-northeast-1:123456789012:function:name"},
+		{"invalid", GeneralizeResource, "invalid"},
+	}
+	for _, tt := range tests {
+		if got := Generalize(tt.arn, tt.generalize); got != tt.want {
+			t.Errorf("%s %s: got %v want %v", tt.arn, tt.generalize, got, tt.want)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	records := []string{
+		`{"eventSource":"s3.amazonaws.com","eventName":"GetObject","resources":[{"type":"AWS::S3::Object","ARN":"arn:aws:s3:::bucket/logs/a.json"},{"type":"AWS::S3::Bucket","ARN":"arn:aws:s3:::bucket"}]}`,
+		`{"eventSource":"s3.amazonaws.com","eventName":"GetObject","resources":[{"type":"AWS::S3::Object","ARN":"arn:aws:s3:::bucket/logs/b.json"},{"type":"AWS::S3::Bucket","ARN":"arn:aws:s3:::bucket"}]}`,
+		`{"eventSource":"s3.amazonaws.com","eventName":"PutObject","resources":[{"type":"AWS::S3::Object","ARN":"arn:aws:s3:::bucket/logs/c.json"},{"type":"AWS::S3::Bucket","ARN":"arn:aws:s3:::bucket"}]}`,
+		`{"eventSource":"s3.amazonaws.com","eventName":"ListObjects","resources":[{"type":"AWS::S3::Bucket","ARN":"arn:aws:s3:::bucket"}]}`,
+		`{"eventSource":"ec2.amazonaws.com","eventName":"DescribeInstances"}`,
+		`{"eventSource":"iam.amazonaws.com","eventName":"CreateUser","errorCode":"AccessDenied"}`,
+		`{"eventSource":"signin.amazonaws.com","eventName":"ConsoleLogin","eventType":"AwsConsoleSignIn"}`,
+	}
+	tests := []struct {
+		generalize string
+		want       string
+	}{
+		{
+			GeneralizeNone,
+			`{"Version":"2012-10-17","Statement":[` +
+				`{"Effect":"Allow","Action":["ec2:DescribeInstances"],"Resource":["*"]},` +
+				`{"Effect":"Allow","Action":["s3:GetObject"],"Resource":["arn:aws:s3:::bucket/logs/a.json","arn:aws:s3:::bucket/logs/b.json"]},` +
+				`{"Effect":"Allow","Action":["s3:ListBucket"],"Resource":["arn:aws:s3:::bucket"]},` +
+				`{"Effect":"Allow","Action":["s3:PutObject"],"Resource":["arn:aws:s3:::bucket/logs/c.json"]}]}`,
+		},
+		{
+			GeneralizePath,
+			`{"Version":"2012-10-17","Statement":[` +
+				`{"Effect":"Allow","Action":["ec2:DescribeInstances"],"Resource":["*"]},` +
+				`{"Effect":"Allow","Action":["s3:GetObject","s3:PutObject"],"Resource":["arn:aws:s3:::bucket/logs/*"]},` +
+				`{"Effect":"Allow","Action":["s3:ListBucket"],"Resource":["arn:aws:s3:::bucket"]}]}`,
+		},
+	}
+	for _, tt := range tests {
+		g, err := NewGenerator(tt.generalize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range records {
+			r := &trail.Record{}
+			if err := r.UnmarshalJSON([]byte(rec)); err != nil {
+				t.Fatal(err)
+			}
+			g.Add(r)
+		}
+		b, err := json.Marshal(g.Policy())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(string(b), tt.want, nil); diff != "" {
+			t.Errorf("%s: %s", tt.generalize, diff)
+		}
+	}
+	if _, err := NewGenerator("all"); err == nil {
+		t.Error("want error")
+	}
+}
@@ -0,0 +1,251 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+const barWidth = 50
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// Histogram is the counts of events per time bucket, optionally split by the dimension
+type Histogram struct {
+	Bucket time.Duration `json:"bucket"`
+	// SplitBy is the field path of the dimension (eg. eventSource). If it is empty, the counts are not split
+	SplitBy string `json:"splitBy,omitempty"`
+	// Counts are the counts per the start of buckets (in unix seconds) and the values of the dimension
+	Counts map[int64]map[string]int64 `json:"counts"`
+}
+
+// NewHistogram returns the Histogram
+func NewHistogram(bucket time.Duration, splitBy string) *Histogram {
+	return &Histogram{Bucket: bucket, SplitBy: splitBy, Counts: map[int64]map[string]int64{}}
+}
+
+// Add counts the event at t with the value of the dimension
+func (h *Histogram) Add(t time.Time, key string) {
+	b := t.UTC().Truncate(h.Bucket).Unix()
+	if h.Counts[b] == nil {
+		h.Counts[b] = map[string]int64{}
+	}
+	h.Counts[b][key] += 1
+}
+
+// buckets returns the starts of all buckets between the first and the last events
+func (h *Histogram) buckets() []time.Time {
+	if len(h.Counts) == 0 {
+		return nil
+	}
+	var first, last int64
+	for b := range h.Counts {
+		if first == 0 || b < first {
+			first = b
+		}
+		if b > last {
+			last = b
+		}
+	}
+	ts := []time.Time{}
+	for t := time.Unix(first, 0).UTC(); !t.After(time.Unix(last, 0)); t = t.Add(h.Bucket) {
+		ts = append(ts, t)
+	}
+	return ts
+}
+
+// keys returns the values of the dimension in descending order of total counts
+func (h *Histogram) keys() ([]string, map[string]int64) {
+	totals := map[string]int64{}
+	for _, c := range h.Counts {
+		for k, v := range c {
+			totals[k] += v
+		}
+	}
+	keys := []string{}
+	for k := range totals {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if totals[keys[i]] != totals[keys[j]] {
+			return totals[keys[i]] > totals[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys, totals
+}
+
+func (h *Histogram) count(t time.Time, key string) int64 {
+	return h.Counts[t.Unix()][key]
+}
+
+// Write writes the histogram in the format (table, json, csv or markdown).
+// table is the bar chart, or the sparklines per the value of the dimension if it is split.
+func (h *Histogram) Write(w io.Writer, format string) error {
+	switch format {
+	case "", "table":
+		if h.SplitBy == "" {
+			return h.writeBars(w)
+		}
+		return h.writeSparklines(w)
+	case "json":
+		return h.writeJSON(w)
+	case "csv":
+		return h.writeCSV(w)
+	case "markdown":
+		return h.writeMarkdown(w)
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+func (h *Histogram) writeBars(w io.Writer) error {
+	ts := h.buckets()
+	max := int64(0)
+	for _, t := range ts {
+		if c := h.count(t, ""); c > max {
+			max = c
+		}
+	}
+	for _, t := range ts {
+		c := h.count(t, "")
+		n := int(c * barWidth / max)
+		bar := strings.Repeat("█", n)
+		if n == 0 && c > 0 {
+			bar = "▏"
+		}
+		if _, err := fmt.Fprintf(w, "%s  %-*s  %d\n", t.Format(time.RFC3339), barWidth, bar, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Histogram) writeSparklines(w io.Writer) error {
+	ts := h.buckets()
+	if len(ts) == 0 {
+		return nil
+	}
+	keys, totals := h.keys()
+	width := len(h.SplitBy)
+	for _, k := range keys {
+		if len(k) > width {
+			width = len(k)
+		}
+	}
+	if _, err := fmt.Fprintf(w, "%-*s  %s - %s (%s)\n", width, h.SplitBy, ts[0].Format(time.RFC3339), ts[len(ts)-1].Add(h.Bucket).Format(time.RFC3339), h.Bucket); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		max := int64(0)
+		for _, t := range ts {
+			if c := h.count(t, k); c > max {
+				max = c
+			}
+		}
+		line := make([]rune, 0, len(ts))
+		for _, t := range ts {
+			c := h.count(t, k)
+			if c == 0 {
+				line = append(line, ' ')
+				continue
+			}
+			line = append(line, sparks[int((c*int64(len(sparks))-1)/max)])
+		}
+		if _, err := fmt.Fprintf(w, "%-*s  %s  %d\n", width, k, string(line), totals[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type histogramRow struct {
+	Time  string  `json:"time"`
+	Key   *string `json:"key,omitempty"`
+	Count int64   `json:"count"`
+}
+
+// rows returns the rows of all buckets (and all values of the dimension)
+func (h *Histogram) rows() []histogramRow {
+	rows := []histogramRow{}
+	keys := []string{""}
+	if h.SplitBy != "" {
+		keys, _ = h.keys()
+	}
+	for _, t := range h.buckets() {
+		for _, k := range keys {
+			r := histogramRow{Time: t.Format(time.RFC3339), Count: h.count(t, k)}
+			if h.SplitBy != "" {
+				k := k
+				r.Key = &k
+			}
+			rows = append(rows, r)
+		}
+	}
+	return rows
+}
+
+// writeJSON writes the histogram as {"bucketSeconds":3600,"splitBy":"...","rows":[{"time":"...","key":"...","count":0}]}
+func (h *Histogram) writeJSON(w io.Writer) error {
+	b, err := json.Marshal(struct {
+		BucketSeconds int64          `json:"bucketSeconds"`
+		SplitBy       string         `json:"splitBy,omitempty"`
+		Rows          []histogramRow `json:"rows"`
+	}{int64(h.Bucket / time.Second), h.SplitBy, h.rows()})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+func (h *Histogram) header() []string {
+	if h.SplitBy == "" {
+		return []string{"time", "count"}
+	}
+	return []string{"time", h.SplitBy, "count"}
+}
+
+func (row histogramRow) cells() []string {
+	if row.Key == nil {
+		return []string{row.Time, strconv.FormatInt(row.Count, 10)}
+	}
+	return []string{row.Time, *row.Key, strconv.FormatInt(row.Count, 10)}
+}
+
+func (h *Histogram) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(h.header()); err != nil {
+		return err
+	}
+	for _, row := range h.rows() {
+		if err := cw.Write(row.cells()); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (h *Histogram) writeMarkdown(w io.Writer) error {
+	header := h.header()
+	if _, err := fmt.Fprintf(w, "| %s |\n|%s ---: |\n", strings.Join(header, " | "), strings.Repeat(" --- |", len(header)-1)); err != nil {
+		return err
+	}
+	for _, row := range h.rows() {
+		cells := row.cells()
+		for i, c := range cells {
+			cells[i] = escapeMarkdown(c)
+		}
+		if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
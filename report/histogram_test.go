@@ -0,0 +1,91 @@
+package report
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHistogram(t *testing.T) {
+	events := []struct {
+		t   time.Time
+		key string
+	}{
+		{time.Date(2022, 2, 3, 14, 5, 0, 0, time.UTC), "s3.amazonaws.com"},
+		{time.Date(2022, 2, 3, 14, 59, 59, 0, time.UTC), "s3.amazonaws.com"},
+		{time.Date(2022, 2, 3, 16, 0, 0, 0, time.UTC), "iam.amazonaws.com"},
+		{time.Date(2022, 2, 3, 16, 30, 0, 0, time.FixedZone("JST", 9*60*60)), "s3.amazonaws.com"},
+	}
+	tests := []struct {
+		splitBy string
+		format  string
+		want    string
+		// prefix compares only the first lines
+		prefix bool
+	}{
+		{
+			"",
+			"table",
+			"2022-02-03T07:00:00Z  █████████████████████████                           1\n" +
+				"2022-02-03T08:00:00Z                                                      0\n",
+			true,
+		},
+		{
+			"",
+			"csv",
+			"time,count\n2022-02-03T07:00:00Z,1\n2022-02-03T08:00:00Z,0\n2022-02-03T09:00:00Z,0\n" +
+				"2022-02-03T10:00:00Z,0\n2022-02-03T11:00:00Z,0\n2022-02-03T12:00:00Z,0\n2022-02-03T13:00:00Z,0\n" +
+				"2022-02-03T14:00:00Z,2\n2022-02-03T15:00:00Z,0\n2022-02-03T16:00:00Z,1\n",
+			false,
+		},
+		{
+			"eventSource",
+			"json",
+			`{"bucketSeconds":3600,"splitBy":"eventSource","rows":[` +
+				`{"time":"2022-02-03T07:00:00Z","key":"s3.amazonaws.com","count":1},{"time":"2022-02-03T07:00:00Z","key":"iam.amazonaws.com","count":0},` +
+				`{"time":"2022-02-03T08:00:00Z","key":"s3.amazonaws.com","count":0},{"time":"2022-02-03T08:00:00Z","key":"iam.amazonaws.com","count":0},` +
+				`{"time":"2022-02-03T09:00:00Z","key":"s3.amazonaws.com","count":0},{"time":"2022-02-03T09:00:00Z","key":"iam.amazonaws.com","count":0},` +
+				`{"time":"2022-02-03T10:00:00Z","key":"s3.amazonaws.com","count":0},{"time":"2022-02-03T10:00:00Z","key":"iam.amazonaws.com","count":0},` +
+				`{"time":"2022-02-03T11:00:00Z","key":"s3.amazonaws.com","count":0},{"time":"2022-02-03T11:00:00Z","key":"iam.amazonaws.com","count":0},` +
+				`{"time":"2022-02-03T12:00:00Z","key":"s3.amazonaws.com","count":0},{"time":"2022-02-03T12:00:00Z","key":"iam.amazonaws.com","count":0},` +
+				`{"time":"2022-02-03T13:00:00Z","key":"s3.amazonaws.com","count":0},{"time":"2022-02-03T13:00:00Z","key":"iam.amazonaws.com","count":0},` +
+				`{"time":"2022-02-03T14:00:00Z","key":"s3.amazonaws.com","count":2},{"time":"2022-02-03T14:00:00Z","key":"iam.amazonaws.com","count":0},` +
+				`{"time":"2022-02-03T15:00:00Z","key":"s3.amazonaws.com","count":0},{"time":"2022-02-03T15:00:00Z","key":"iam.amazonaws.com","count":0},` +
+				`{"time":"2022-02-03T16:00:00Z","key":"s3.amazonaws.com","count":0},{"time":"2022-02-03T16:00:00Z","key":"iam.amazonaws.com","count":1}]}` + "\n",
+
+			false,
+		},
+		{
+			"eventSource",
+			"table",
+			"eventSource        2022-02-03T07:00:00Z - 2022-02-03T17:00:00Z (1h0m0s)\n" +
+				"s3.amazonaws.com   ▄      █    3\n" +
+				"iam.amazonaws.com           █  1\n",
+
+			false,
+		},
+	}
+	for _, tt := range tests {
+		h := NewHistogram(time.Hour, tt.splitBy)
+		for _, e := range events {
+			key := ""
+			if tt.splitBy != "" {
+				key = e.key
+			}
+			h.Add(e.t, key)
+		}
+		buf := new(bytes.Buffer)
+		if err := h.Write(buf, tt.format); err != nil {
+			t.Fatal(err)
+		}
+		got := buf.String()
+		if tt.prefix && len(got) > len(tt.want) {
+			got = got[:len(tt.want)]
+		}
+		if diff := cmp.Diff(got, tt.want, nil); diff != "" {
+			t.Errorf("%s %s: %s", tt.splitBy, tt.format, diff)
+		}
+	}
+}
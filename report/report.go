@@ -0,0 +1,260 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/olekukonko/tablewriter"
+)
+
+// Formats are the supported output formats
+var Formats = []string{"table", "json", "csv", "markdown"}
+
+// Report is the result of analysis consisting of sections
+type Report struct {
+	// Value is the name of values (eg. count, bytes)
+	Value string
+	// Title is the title of values in table and markdown (eg. Count, Size)
+	Title string
+	// FormatValue formats values in table and markdown. If it is nil, values are written as they are
+	FormatValue func(v int64) string
+	Sections    []*Section
+}
+
+// Section is the section of the report
+type Section struct {
+	// Name is the stable name of the section in json and csv (eg. eventSource)
+	Name string
+	// Title is the title of the section in table and markdown (eg. Event Source)
+	Title string
+	// Fields are the field paths of the keys of rows if the section is grouped by them (eg. eventSource, eventName)
+	Fields []string
+	Rows   []*Row
+}
+
+// Row is the row of the section
+type Row struct {
+	Key string
+	// Label is the label of the key in table and markdown. If it is empty, the key is used
+	Label string
+	// Keys are the values of the fields of the section. Key is the values joined with ","
+	Keys  []string
+	Value int64
+}
+
+// New returns the Report
+func New(value, title string) *Report {
+	return &Report{Value: value, Title: title}
+}
+
+// AddSection adds the section with the rows of counts sorted by key
+func (r *Report) AddSection(name, title string, counts map[string]int64) *Section {
+	keys := []string{}
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	s := &Section{Name: name, Title: title}
+	for _, k := range keys {
+		s.Rows = append(s.Rows, &Row{Key: k, Value: counts[k]})
+	}
+	r.Sections = append(r.Sections, s)
+	return s
+}
+
+// AddGroupSection adds the section grouped by the fields with the rows of counts.
+// The keys of counts are the values of the fields joined with sep.
+func (r *Report) AddGroupSection(fields []string, counts map[string]int64, sep string) *Section {
+	name := strings.Join(fields, ",")
+	s := &Section{Name: name, Title: name, Fields: fields}
+	for k, v := range counts {
+		keys := strings.Split(k, sep)
+		s.Rows = append(s.Rows, &Row{Key: strings.Join(keys, ","), Keys: keys, Value: v})
+	}
+	s.SortByValue()
+	r.Sections = append(r.Sections, s)
+	return s
+}
+
+// SortByValue sorts the rows in descending order of values (and in ascending order of keys for the same value)
+func (s *Section) SortByValue() {
+	sort.SliceStable(s.Rows, func(i, j int) bool {
+		if s.Rows[i].Value != s.Rows[j].Value {
+			return s.Rows[i].Value > s.Rows[j].Value
+		}
+		return s.Rows[i].Key < s.Rows[j].Key
+	})
+}
+
+// Limit limits the rows to the first n rows. If n <= 0, all rows are kept
+func (s *Section) Limit(n int) {
+	if n > 0 && len(s.Rows) > n {
+		s.Rows = s.Rows[:n]
+	}
+}
+
+// Write writes the report in the format (table, json, csv or markdown)
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case "", "table":
+		return r.writeTable(w)
+	case "json":
+		return r.writeJSON(w)
+	case "csv":
+		return r.writeCSV(w)
+	case "markdown":
+		return r.writeMarkdown(w)
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+func (r *Report) formatValue(v int64) string {
+	if r.FormatValue == nil {
+		return strconv.FormatInt(v, 10)
+	}
+	return r.FormatValue(v)
+}
+
+func (row *Row) label() string {
+	if row.Label != "" {
+		return row.Label
+	}
+	if row.Keys != nil {
+		return strings.Join(row.Keys, " ")
+	}
+	return row.Key
+}
+
+func (r *Report) writeTable(w io.Writer) error {
+	data := [][]string{}
+	data = append(data, []string{"", "", ""})
+	for _, s := range r.Sections {
+		for _, row := range s.Rows {
+			l := row.label()
+			if l != "" {
+				l = fmt.Sprintf("%s:", l)
+			}
+			data = append(data, []string{s.Title, l, r.formatValue(row.Value)})
+		}
+		data = append(data, []string{"", "", ""})
+	}
+	if _, err := fmt.Fprintln(w, ""); err != nil {
+		return err
+	}
+	table := newTable(w, []string{"", "", r.Title}, []int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_RIGHT})
+	table.SetAutoMergeCellsByColumnIndex([]int{0})
+	table.AppendBulk(data)
+	table.Render()
+	return nil
+}
+
+// newTable returns the borderless table
+func newTable(w io.Writer, header []string, alignments []int) *tablewriter.Table {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader(header)
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetColumnAlignment(alignments)
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetRowSeparator("")
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	return table
+}
+
+type jsonSection struct {
+	Name   string            `json:"name"`
+	Fields []string          `json:"fields,omitempty"`
+	Rows   []json.RawMessage `json:"rows"`
+}
+
+// writeJSON writes the report as {"sections":[{"name":"...","rows":[{"key":"...","<value>":0}]}]}.
+// The rows of the sections grouped by fields have the values of the fields as "keys"
+func (r *Report) writeJSON(w io.Writer) error {
+	sections := []jsonSection{}
+	for _, s := range r.Sections {
+		js := jsonSection{Name: s.Name, Fields: s.Fields, Rows: []json.RawMessage{}}
+		for _, row := range s.Rows {
+			k, err := json.Marshal(row.Key)
+			if err != nil {
+				return err
+			}
+			if row.Keys == nil {
+				js.Rows = append(js.Rows, json.RawMessage(fmt.Sprintf(`{"key":%s,%q:%d}`, k, r.Value, row.Value)))
+				continue
+			}
+			ks, err := json.Marshal(row.Keys)
+			if err != nil {
+				return err
+			}
+			js.Rows = append(js.Rows, json.RawMessage(fmt.Sprintf(`{"key":%s,"keys":%s,%q:%d}`, k, ks, r.Value, row.Value)))
+		}
+		sections = append(sections, js)
+	}
+	b, err := json.Marshal(map[string]interface{}{"sections": sections})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// writeCSV writes the report as the rows of section, key and value
+func (r *Report) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"section", "key", r.Value}); err != nil {
+		return err
+	}
+	for _, s := range r.Sections {
+		for _, row := range s.Rows {
+			if err := cw.Write([]string{s.Name, row.Key, strconv.FormatInt(row.Value, 10)}); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeMarkdown writes the report as the tables of sections
+func (r *Report) writeMarkdown(w io.Writer) error {
+	for i, s := range r.Sections {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w, ""); err != nil {
+				return err
+			}
+		}
+		headers := []string{s.Title}
+		if s.Fields != nil {
+			headers = s.Fields
+		}
+		if _, err := fmt.Fprintf(w, "## %s\n\n| %s | %s |\n|%s ---: |\n", s.Title, strings.Join(headers, " | "), r.Title, strings.Repeat(" --- |", len(headers))); err != nil {
+			return err
+		}
+		for _, row := range s.Rows {
+			cells := []string{row.label()}
+			if s.Fields != nil {
+				cells = append([]string{}, row.Keys...)
+			}
+			for i, c := range cells {
+				cells[i] = escapeMarkdown(c)
+			}
+			if _, err := fmt.Fprintf(w, "| %s | %s |\n", strings.Join(cells, " | "), escapeMarkdown(r.formatValue(row.Value))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func escapeMarkdown(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
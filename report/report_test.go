@@ -0,0 +1,89 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWrite(t *testing.T) {
+	r := New("bytes", "Size")
+	r.FormatValue = func(v int64) string {
+		return fmt.Sprintf("%dB", v)
+	}
+	r.AddSection("region", "Region", map[string]int64{"us-east-1": 20, "ap-northeast-1": 10})
+	r.Sections = append(r.Sections, &Section{
+		Name:  "total",
+		Title: "Total",
+		Rows:  []*Row{{Key: "total", Label: "a|b", Value: 30}},
+	})
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			"json",
+			`{"sections":[{"name":"region","rows":[{"key":"ap-northeast-1","bytes":10},{"key":"us-east-1","bytes":20}]},{"name":"total","rows":[{"key":"total","bytes":30}]}]}` + "\n",
+		},
+		{
+			"csv",
+			"section,key,bytes\nregion,ap-northeast-1,10\nregion,us-east-1,20\ntotal,total,30\n",
+		},
+		{
+			"markdown",
+			"## Region\n\n| Region | Size |\n| --- | ---: |\n| ap-northeast-1 | 10B |\n| us-east-1 | 20B |\n\n" +
+				"## Total\n\n| Total | Size |\n| --- | ---: |\n| a\\|b | 30B |\n",
+		},
+	}
+	for _, tt := range tests {
+		buf := new(bytes.Buffer)
+		if err := r.Write(buf, tt.format); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(buf.String(), tt.want, nil); diff != "" {
+			t.Errorf("%s: %s", tt.format, diff)
+		}
+	}
+	if err := r.Write(new(bytes.Buffer), "xml"); err == nil {
+		t.Error("want error")
+	}
+}
+
+func TestAddGroupSection(t *testing.T) {
+	r := New("count", "Count")
+	s := r.AddGroupSection([]string{"eventSource", "eventName"}, map[string]int64{
+		"s3.amazonaws.com\x00GetObject":  3,
+		"iam.amazonaws.com\x00ListUsers": 1,
+		"s3.amazonaws.com\x00PutObject":  3,
+		"\x00ConsoleLogin":               2,
+	}, "\x00")
+	s.Limit(3)
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			"json",
+			`{"sections":[{"name":"eventSource,eventName","fields":["eventSource","eventName"],"rows":[` +
+				`{"key":"s3.amazonaws.com,GetObject","keys":["s3.amazonaws.com","GetObject"],"count":3},` +
+				`{"key":"s3.amazonaws.com,PutObject","keys":["s3.amazonaws.com","PutObject"],"count":3},` +
+				`{"key":",ConsoleLogin","keys":["","ConsoleLogin"],"count":2}]}]}` + "\n",
+		},
+		{
+			"markdown",
+			"## eventSource,eventName\n\n| eventSource | eventName | Count |\n| --- | --- | ---: |\n" +
+				"| s3.amazonaws.com | GetObject | 3 |\n| s3.amazonaws.com | PutObject | 3 |\n|  | ConsoleLogin | 2 |\n",
+		},
+	}
+	for _, tt := range tests {
+		buf := new(bytes.Buffer)
+		if err := r.Write(buf, tt.format); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(buf.String(), tt.want, nil); diff != "" {
+			t.Errorf("%s: %s", tt.format, diff)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/olekukonko/tablewriter"
+	"github.com/pepabo/trail-digger/trail"
+)
+
+// sensitiveEventSources are the services whose write actions are sensitive
+var sensitiveEventSources = map[string]bool{
+	"access-analyzer.amazonaws.com": true,
+	"cloudtrail.amazonaws.com":      true,
+	"config.amazonaws.com":          true,
+	"guardduty.amazonaws.com":       true,
+	"iam.amazonaws.com":             true,
+	"kms.amazonaws.com":             true,
+	"organizations.amazonaws.com":   true,
+	"secretsmanager.amazonaws.com":  true,
+	"securityhub.amazonaws.com":     true,
+	"sso.amazonaws.com":             true,
+}
+
+// sensitiveEventNames are the sensitive write actions of the other services
+var sensitiveEventNames = map[string]bool{
+	"AuthorizeSecurityGroupEgress":     true,
+	"AuthorizeSecurityGroupIngress":    true,
+	"CreateKeyPair":                    true,
+	"DeleteBucketPolicy":               true,
+	"DeleteBucketPublicAccessBlock":    true,
+	"DeleteFlowLogs":                   true,
+	"DisableEbsEncryptionByDefault":    true,
+	"ImportKeyPair":                    true,
+	"ModifyDBClusterSnapshotAttribute": true,
+	"ModifyDBSnapshotAttribute":        true,
+	"ModifyImageAttribute":             true,
+	"ModifyInstanceAttribute":          true,
+	"ModifySnapshotAttribute":          true,
+	"PutBucketAcl":                     true,
+	"PutBucketPolicy":                  true,
+	"PutBucketPublicAccessBlock":       true,
+	"PutObjectAcl":                     true,
+}
+
+// IsSensitiveWrite reports whether the event is the sensitive write action (eg. IAM, KMS, CloudTrail or S3 bucket policies)
+func IsSensitiveWrite(r *trail.Record) bool {
+	if r.ReadOnly {
+		return false
+	}
+	return sensitiveEventSources[r.EventSource] || sensitiveEventNames[r.EventName]
+}
+
+// Whois is the summary of the activity of the principal
+type Whois struct {
+	principal         string
+	top               int
+	firstSeen         time.Time
+	lastSeen          time.Time
+	events            int64
+	errors            int64
+	identities        map[string]int64
+	sourceIPAddresses map[string]int64
+	userAgents        map[string]int64
+	regions           map[string]int64
+	eventSources      map[string]int64
+	apiCalls          map[string]*apiCall
+	sensitiveActions  []*action
+}
+
+type countRow struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+type apiCall struct {
+	EventSource string `json:"eventSource"`
+	EventName   string `json:"eventName"`
+	Count       int64  `json:"count"`
+	Errors      int64  `json:"errors"`
+}
+
+type action struct {
+	EventTime       time.Time `json:"eventTime"`
+	EventID         string    `json:"eventID"`
+	EventSource     string    `json:"eventSource"`
+	EventName       string    `json:"eventName"`
+	AwsRegion       string    `json:"awsRegion"`
+	SourceIPAddress string    `json:"sourceIPAddress"`
+	Arn             string    `json:"arn"`
+	ErrorCode       string    `json:"errorCode,omitempty"`
+}
+
+// NewWhois returns the Whois of the principal. The counts are limited to the top N (0 for all)
+func NewWhois(principal string, top int) *Whois {
+	return &Whois{
+		principal:         principal,
+		top:               top,
+		identities:        map[string]int64{},
+		sourceIPAddresses: map[string]int64{},
+		userAgents:        map[string]int64{},
+		regions:           map[string]int64{},
+		eventSources:      map[string]int64{},
+		apiCalls:          map[string]*apiCall{},
+	}
+}
+
+// Add adds the event called by the principal to the summary
+func (wi *Whois) Add(r *trail.Record) {
+	if wi.firstSeen.IsZero() || r.EventTime.Before(wi.firstSeen) {
+		wi.firstSeen = r.EventTime
+	}
+	if r.EventTime.After(wi.lastSeen) {
+		wi.lastSeen = r.EventTime
+	}
+	wi.events += 1
+	if r.ErrorCode != "" {
+		wi.errors += 1
+	}
+	wi.identities[r.UserIdentity.Arn] += 1
+	wi.sourceIPAddresses[r.SourceIPAddress] += 1
+	wi.userAgents[r.UserAgent] += 1
+	wi.regions[r.AwsRegion] += 1
+	wi.eventSources[r.EventSource] += 1
+	k := fmt.Sprintf("%s:%s", r.EventSource, r.EventName)
+	c, ok := wi.apiCalls[k]
+	if !ok {
+		c = &apiCall{EventSource: r.EventSource, EventName: r.EventName}
+		wi.apiCalls[k] = c
+	}
+	c.Count += 1
+	if r.ErrorCode != "" {
+		c.Errors += 1
+	}
+	if IsSensitiveWrite(r) {
+		wi.sensitiveActions = append(wi.sensitiveActions, &action{
+			EventTime:       r.EventTime,
+			EventID:         r.EventID,
+			EventSource:     r.EventSource,
+			EventName:       r.EventName,
+			AwsRegion:       r.AwsRegion,
+			SourceIPAddress: r.SourceIPAddress,
+			Arn:             r.UserIdentity.Arn,
+			ErrorCode:       r.ErrorCode,
+		})
+	}
+}
+
+// counts returns the rows of counts in descending order of counts limited to the top N
+func (wi *Whois) counts(m map[string]int64) []countRow {
+	s := &Section{}
+	for k, v := range m {
+		s.Rows = append(s.Rows, &Row{Key: k, Value: v})
+	}
+	s.SortByValue()
+	s.Limit(wi.top)
+	rows := []countRow{}
+	for _, r := range s.Rows {
+		rows = append(rows, countRow{Key: r.Key, Count: r.Value})
+	}
+	return rows
+}
+
+// calls returns the API calls in descending order of counts limited to the top N
+func (wi *Whois) calls() []*apiCall {
+	calls := []*apiCall{}
+	for _, c := range wi.apiCalls {
+		calls = append(calls, c)
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		if calls[i].Count != calls[j].Count {
+			return calls[i].Count > calls[j].Count
+		}
+		if calls[i].EventSource != calls[j].EventSource {
+			return calls[i].EventSource < calls[j].EventSource
+		}
+		return calls[i].EventName < calls[j].EventName
+	})
+	if wi.top > 0 && len(calls) > wi.top {
+		calls = calls[:wi.top]
+	}
+	return calls
+}
+
+// Write writes the summary in the format (table or json)
+func (wi *Whois) Write(w io.Writer, format string) error {
+	switch format {
+	case "", "table":
+		return wi.writeTable(w)
+	case "json":
+		return wi.writeJSON(w)
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+func (wi *Whois) writeJSON(w io.Writer) error {
+	var firstSeen, lastSeen *time.Time
+	if wi.events > 0 {
+		firstSeen = &wi.firstSeen
+		lastSeen = &wi.lastSeen
+	}
+	sensitiveActions := wi.sensitiveActions
+	if sensitiveActions == nil {
+		sensitiveActions = []*action{}
+	}
+	b, err := json.Marshal(struct {
+		Principal         string     `json:"principal"`
+		FirstSeen         *time.Time `json:"firstSeen"`
+		LastSeen          *time.Time `json:"lastSeen"`
+		Events            int64      `json:"events"`
+		Errors            int64      `json:"errors"`
+		Identities        []countRow `json:"identities"`
+		SourceIPAddresses []countRow `json:"sourceIPAddresses"`
+		UserAgents        []countRow `json:"userAgents"`
+		Regions           []countRow `json:"regions"`
+		EventSources      []countRow `json:"eventSources"`
+		APICalls          []*apiCall `json:"apiCalls"`
+		SensitiveActions  []*action  `json:"sensitiveActions"`
+	}{
+		Principal:         wi.principal,
+		FirstSeen:         firstSeen,
+		LastSeen:          lastSeen,
+		Events:            wi.events,
+		Errors:            wi.errors,
+		Identities:        wi.counts(wi.identities),
+		SourceIPAddresses: wi.counts(wi.sourceIPAddresses),
+		UserAgents:        wi.counts(wi.userAgents),
+		Regions:           wi.counts(wi.regions),
+		EventSources:      wi.counts(wi.eventSources),
+		APICalls:          wi.calls(),
+		SensitiveActions:  sensitiveActions,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+func (wi *Whois) writeTable(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "\nPrincipal:  %s\n", wi.principal); err != nil {
+		return err
+	}
+	if wi.events == 0 {
+		_, err := fmt.Fprintln(w, "No events found")
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "First seen: %s\nLast seen:  %s\nEvents:     %d (errors: %d)\n",
+		wi.firstSeen.Format(time.RFC3339), wi.lastSeen.Format(time.RFC3339), wi.events, wi.errors); err != nil {
+		return err
+	}
+
+	r := New("count", "Count")
+	for _, s := range []struct {
+		title  string
+		counts map[string]int64
+	}{
+		{"Identity", wi.identities},
+		{"Source IP Address", wi.sourceIPAddresses},
+		{"User Agent", wi.userAgents},
+		{"Region", wi.regions},
+		{"Event Source", wi.eventSources},
+	} {
+		sec := &Section{Title: s.title}
+		for _, c := range wi.counts(s.counts) {
+			sec.Rows = append(sec.Rows, &Row{Key: c.Key, Value: c.Count})
+		}
+		r.Sections = append(r.Sections, sec)
+	}
+	if err := r.writeTable(w); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintln(w, "\nAPI calls:"); err != nil {
+		return err
+	}
+	table := newTable(w, []string{"Event Source", "Event Name", "Count", "Errors"}, []int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT})
+	for _, c := range wi.calls() {
+		table.Append([]string{c.EventSource, c.EventName, strconv.FormatInt(c.Count, 10), strconv.FormatInt(c.Errors, 10)})
+	}
+	table.Render()
+
+	if _, err := fmt.Fprintln(w, "\nSensitive write actions:"); err != nil {
+		return err
+	}
+	if len(wi.sensitiveActions) == 0 {
+		_, err := fmt.Fprintln(w, "  (none)")
+		return err
+	}
+	table = newTable(w, []string{"Event Time", "Event Source", "Event Name", "Region", "Source IP Address", "Error Code"}, []int{tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT, tablewriter.ALIGN_LEFT})
+	for _, a := range wi.sensitiveActions {
+		table.Append([]string{a.EventTime.Format(time.RFC3339), a.EventSource, a.EventName, a.AwsRegion, a.SourceIPAddress, a.ErrorCode})
+	}
+	table.Render()
+	return nil
+}
@@ -0,0 +1,41 @@
+package report
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pepabo/trail-digger/trail"
+)
+
+func TestWhois(t *testing.T) {
+	records := []string{
+		`{"eventID":"e1","eventTime":"2022-02-03T14:05:00Z","eventSource":"s3.amazonaws.com","eventName":"ListBuckets","readOnly":true,"awsRegion":"us-east-1","sourceIPAddress":"192.0.2.1","userAgent":"aws-cli/2","userIdentity":{"arn":"arn:aws:iam::123456789012:user/alice"}}`,
+		`{"eventID":"e2","eventTime":"2022-02-03T14:06:00Z","eventSource":"s3.amazonaws.com","eventName":"ListBuckets","readOnly":true,"awsRegion":"us-east-1","sourceIPAddress":"192.0.2.2","userAgent":"aws-cli/2","userIdentity":{"arn":"arn:aws:iam::123456789012:user/alice"}}`,
+		`{"eventID":"e3","eventTime":"2022-02-03T14:07:00Z","eventSource":"iam.amazonaws.com","eventName":"CreateUser","readOnly":false,"awsRegion":"us-east-1","sourceIPAddress":"192.0.2.1","userAgent":"console","errorCode":"AccessDenied","userIdentity":{"arn":"arn:aws:iam::123456789012:user/alice"}}`,
+		`{"eventID":"e4","eventTime":"2022-02-03T14:08:00Z","eventSource":"ec2.amazonaws.com","eventName":"StartInstances","readOnly":false,"awsRegion":"ap-northeast-1","sourceIPAddress":"192.0.2.1","userAgent":"console","userIdentity":{"arn":"arn:aws:iam::123456789012:user/alice"}}`,
+	}
+	wi := NewWhois("arn:aws:iam::123456789012:user/alice", 1)
+	for _, rec := range records {
+		r := &trail.Record{}
+		if err := r.UnmarshalJSON([]byte(rec)); err != nil {
+			t.Fatal(err)
+		}
+		wi.Add(r)
+	}
+	buf := new(bytes.Buffer)
+	if err := wi.Write(buf, "json"); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"principal":"arn:aws:iam::123456789012:user/alice","firstSeen":"2022-02-03T14:05:00Z","lastSeen":"2022-02-03T14:08:00Z","events":4,"errors":1,` +
+		`"identities":[{"key":"arn:aws:iam::123456789012:user/alice","count":4}],` +
+		`"sourceIPAddresses":[{"key":"192.0.2.1","count":3}],` +
+		`"userAgents":[{"key":"aws-cli/2","count":2}],` +
+		`"regions":[{"key":"us-east-1","count":3}],` +
+		`"eventSources":[{"key":"s3.amazonaws.com","count":2}],` +
+		`"apiCalls":[{"eventSource":"s3.amazonaws.com","eventName":"ListBuckets","count":2,"errors":0}],` +
+		`"sensitiveActions":[{"eventTime":"2022-02-03T14:07:00Z","eventID":"e3","eventSource":"iam.amazonaws.com","eventName":"CreateUser","awsRegion":"us-east-1","sourceIPAddress":"192.0.2.1","arn":"arn:aws:iam::123456789012:user/alice","errorCode":"AccessDenied"}]}` + "\n"
+	if diff := cmp.Diff(buf.String(), want, nil); diff != "" {
+		t.Errorf("%s", diff)
+	}
+}
@@ -0,0 +1,70 @@
+package sink
+
+import (
+	"encoding/csv"
+	"io"
+
+	"github.com/pepabo/trail-digger/trail"
+)
+
+type csvSink struct {
+	w       *csv.Writer
+	columns []string
+	header  bool
+}
+
+// NewCSV returns the Sink writing the columns of events as CSV (or TSV with comma '\t') with the header row
+func NewCSV(w io.Writer, columns []string, comma rune) Sink {
+	return newCSV(w, columns, comma, false)
+}
+
+// newCSV returns the csv Sink. If header is true, the header row is not written (eg. appending to the existing file)
+func newCSV(w io.Writer, columns []string, comma rune, header bool) Sink {
+	if len(columns) == 0 {
+		columns = DefaultColumns
+	}
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	return &csvSink{w: cw, columns: columns, header: header}
+}
+
+func (s *csvSink) Write(r *trail.Record) error {
+	if err := s.writeHeader(); err != nil {
+		return err
+	}
+	f, err := r.Fields()
+	if err != nil {
+		return err
+	}
+	row := make([]string, 0, len(s.columns))
+	for _, c := range s.columns {
+		v, ok := trail.Lookup(f, c)
+		if !ok {
+			row = append(row, "")
+			continue
+		}
+		row = append(row, trail.ValueString(v))
+	}
+	return s.w.Write(row)
+}
+
+func (s *csvSink) Flush() error {
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func (s *csvSink) Close() error {
+	if err := s.writeHeader(); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func (s *csvSink) writeHeader() error {
+	if s.header {
+		return nil
+	}
+	s.header = true
+	return s.w.Write(s.columns)
+}
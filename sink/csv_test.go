@@ -0,0 +1,62 @@
+package sink
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pepabo/trail-digger/trail"
+)
+
+func TestCSV(t *testing.T) {
+	records := []string{
+		`{"eventTime":"2022-02-03T14:05:00Z","eventName":"PutObject","userIdentity":{"arn":"arn:aws:iam::123456789012:user/alice"},"sourceIPAddress":"192.0.2.1","resources":[{"ARN":"arn:aws:s3:::bucket/key"}]}`,
+		`{"eventTime":"2022-02-03T14:06:00Z","eventName":"CreateUser","userIdentity":{"arn":"arn:aws:iam::123456789012:user/bob"},"errorCode":"AccessDenied","errorMessage":"User: bob is not authorized, \"really\"\nsecond line","readOnly":false}`,
+	}
+	columns := []string{"eventTime", "eventName", "userIdentity.arn", "resources.0.ARN", "errorMessage", "readOnly"}
+	tests := []struct {
+		comma rune
+		want  string
+	}{
+		{
+			',',
+			"eventTime,eventName,userIdentity.arn,resources.0.ARN,errorMessage,readOnly\n" +
+				"2022-02-03T14:05:00Z,PutObject,arn:aws:iam::123456789012:user/alice,arn:aws:s3:::bucket/key,,\n" +
+				"2022-02-03T14:06:00Z,CreateUser,arn:aws:iam::123456789012:user/bob,,\"User: bob is not authorized, \"\"really\"\"\nsecond line\",false\n",
+		},
+		{
+			'\t',
+			"eventTime\teventName\tuserIdentity.arn\tresources.0.ARN\terrorMessage\treadOnly\n" +
+				"2022-02-03T14:05:00Z\tPutObject\tarn:aws:iam::123456789012:user/alice\tarn:aws:s3:::bucket/key\t\t\n" +
+				"2022-02-03T14:06:00Z\tCreateUser\tarn:aws:iam::123456789012:user/bob\t\t\"User: bob is not authorized, \"\"really\"\"\nsecond line\"\tfalse\n",
+		},
+	}
+	for _, tt := range tests {
+		buf := new(bytes.Buffer)
+		s := NewCSV(buf, columns, tt.comma)
+		for _, rec := range records {
+			r := &trail.Record{}
+			if err := r.UnmarshalJSON([]byte(rec)); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Write(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(buf.String(), tt.want, nil); diff != "" {
+			t.Errorf("%s", diff)
+		}
+	}
+
+	// header only
+	buf := new(bytes.Buffer)
+	if err := NewCSV(buf, nil, ',').Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "eventTime,eventSource,eventName,awsRegion,userIdentity.arn,sourceIPAddress,errorCode\n"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
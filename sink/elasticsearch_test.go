@@ -0,0 +1,158 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pepabo/trail-digger/trail"
+)
+
+func TestElasticsearch(t *testing.T) {
+	records := []string{
+		`{"eventID":"e1","eventTime":"2022-02-03T23:59:00Z","eventName":"PutObject"}`,
+		`{"eventID":"e2","eventTime":"2022-02-04T00:01:00Z","eventName":"GetObject"}`,
+		`{"eventID":"e3","eventTime":"2022-02-04T00:02:00Z","eventName":"DeleteObject"}`,
+	}
+	tests := []struct {
+		name     string
+		statuses []int
+		wantErr  bool
+		want     []string
+		requests int
+	}{
+		{"indexed", nil, false, []string{"cloudtrail-2022.02.03/e1", "cloudtrail-2022.02.04/e2", "cloudtrail-2022.02.04/e3"}, 2},
+		{"retry rejected items", []int{http.StatusTooManyRequests}, false, []string{"cloudtrail-2022.02.03/e1", "cloudtrail-2022.02.04/e2", "cloudtrail-2022.02.04/e3"}, 3},
+		{"item error", []int{http.StatusBadRequest}, true, nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				got      []string
+				requests int
+			)
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+					t.Errorf("invalid request: %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+				}
+				if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "pass" {
+					t.Errorf("invalid basic auth: %s %s", u, p)
+				}
+				status := http.StatusOK
+				if requests < len(tt.statuses) {
+					status = tt.statuses[requests]
+				}
+				requests += 1
+				indexed := []string{}
+				sc := bufio.NewScanner(r.Body)
+				for sc.Scan() {
+					action := map[string]map[string]string{}
+					if err := json.Unmarshal(sc.Bytes(), &action); err != nil {
+						t.Error(err)
+					}
+					if !sc.Scan() {
+						t.Error("no document")
+					}
+					indexed = append(indexed, fmt.Sprintf("%s/%s", action["index"]["_index"], action["index"]["_id"]))
+				}
+				if status != http.StatusOK {
+					_, _ = io.WriteString(w, fmt.Sprintf(`{"errors":true,"items":[{"index":{"_id":"e1","status":%d,"error":{"type":"error","reason":"failed"}}}]}`, status))
+					return
+				}
+				got = append(got, indexed...)
+				_, _ = io.WriteString(w, `{"errors":false,"items":[]}`)
+			}))
+			defer ts.Close()
+
+			s, err := NewElasticsearch(context.Background(), Option{
+				Output:    fmt.Sprintf("es://user:pass@%s/cloudtrail", strings.TrimPrefix(ts.URL, "http://")),
+				BatchSize: 2,
+				Workers:   1,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, rec := range records {
+				r := &trail.Record{}
+				if err := r.UnmarshalJSON([]byte(rec)); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.Write(r); err != nil {
+					break
+				}
+			}
+			err = s.Close()
+			if tt.wantErr {
+				if err == nil {
+					t.Error("want error")
+				}
+			} else if err != nil {
+				t.Error(err)
+			}
+			if diff := cmp.Diff(got, tt.want, nil); diff != "" {
+				t.Errorf("%s", diff)
+			}
+			if requests != tt.requests {
+				t.Errorf("got %v want %v", requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestElasticsearchIndentedRecord(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		body string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		body = string(b)
+		_, _ = io.WriteString(w, `{"errors":false,"items":[]}`)
+	}))
+	defer ts.Close()
+
+	s, err := NewElasticsearch(context.Background(), Option{Output: fmt.Sprintf("es://%s/cloudtrail", strings.TrimPrefix(ts.URL, "http://"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &trail.Record{}
+	if err := r.UnmarshalJSON([]byte(`{"eventID":"e1","eventTime":"2022-02-03T23:59:00Z"}`)); err != nil {
+		t.Fatal(err)
+	}
+	// records decoded by others may have indented raw bytes
+	r.Raw = json.RawMessage("{\n  \"eventID\": \"e1\",\n  \"eventTime\": \"2022-02-03T23:59:00Z\"\n}")
+	if err := s.Write(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"index":{"_id":"e1","_index":"cloudtrail-2022.02.03"}}` + "\n" + `{"eventID":"e1","eventTime":"2022-02-03T23:59:00Z"}` + "\n"
+	if diff := cmp.Diff(body, want, nil); diff != "" {
+		t.Errorf("%s", diff)
+	}
+}
+
+func TestNewElasticsearchInvalidURL(t *testing.T) {
+	for _, u := range []string{"es://localhost:9200", "es://localhost:9200/a/b", "es:///cloudtrail"} {
+		if _, err := NewElasticsearch(context.Background(), Option{Output: u}); err == nil {
+			t.Errorf("want error: %s", u)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultBatchSize  = 500
+	defaultWorkers    = 4
+	defaultMaxRetries = 5
+	retryBaseDelay    = 500 * time.Millisecond
+	retryMaxDelay     = 30 * time.Second
+)
+
+// batcher sends events to the remote sink in batches with concurrent workers.
+// The batches which still fail after retries are appended to the dead-letter file if it is specified.
+type batcher struct {
+	ctx       context.Context
+	client    *http.Client
+	url       string
+	header    http.Header
+	gzip      bool
+	batchSize int
+	entries   [][]byte
+	encode    func(entries [][]byte) []byte
+	check     func(res *http.Response, b []byte) error
+	batches   chan []byte
+	closed    bool
+	eg        *errgroup.Group
+	// pending is the batches received by the workers and not sent yet
+	pending sync.WaitGroup
+
+	dlMu       sync.Mutex
+	deadLetter *os.File
+}
+
+// newBatcher returns the batcher posting the batches of entries encoded by encode to url
+func newBatcher(ctx context.Context, url string, header http.Header, opt Option, encode func(entries [][]byte) []byte, check func(res *http.Response, b []byte) error) (*batcher, error) {
+	h, err := parseHeaders(opt.Headers)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range h {
+		header[k] = v
+	}
+	if opt.Gzip {
+		header.Set("Content-Encoding", "gzip")
+	}
+	batchSize := opt.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	workers := opt.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	var dl *os.File
+	if opt.DeadLetter != "" {
+		dl, err = os.OpenFile(filepath.Clean(opt.DeadLetter), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+	}
+	eg, ctx := errgroup.WithContext(ctx)
+	batches := make(chan []byte)
+	b := &batcher{
+		ctx:        ctx,
+		client:     &http.Client{Timeout: time.Minute},
+		url:        url,
+		header:     header,
+		gzip:       opt.Gzip,
+		batchSize:  batchSize,
+		encode:     encode,
+		check:      check,
+		batches:    batches,
+		eg:         eg,
+		deadLetter: dl,
+	}
+	for i := 0; i < workers; i++ {
+		eg.Go(func() error {
+			for body := range batches {
+				err := b.send(ctx, body)
+				b.pending.Done()
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return b, nil
+}
+
+func (b *batcher) send(ctx context.Context, body []byte) error {
+	req := body
+	if b.gzip {
+		buf := new(bytes.Buffer)
+		zw := gzip.NewWriter(buf)
+		if _, err := zw.Write(body); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		req = buf.Bytes()
+	}
+	err := post(ctx, b.client, b.url, b.header, req, defaultMaxRetries, b.check)
+	if err == nil || b.deadLetter == nil || ctx.Err() != nil {
+		return err
+	}
+	log.Error().Err(err).Str("dead_letter", b.deadLetter.Name()).Msg("Failed to send events")
+	b.dlMu.Lock()
+	defer b.dlMu.Unlock()
+	if _, err := b.deadLetter.Write(body); err != nil {
+		return err
+	}
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		if _, err := b.deadLetter.Write([]byte{'\n'}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add adds the entry to the batch, and sends the batch if it is full
+func (b *batcher) add(e []byte) error {
+	b.entries = append(b.entries, e)
+	if len(b.entries) < b.batchSize {
+		return nil
+	}
+	return b.flush()
+}
+
+func (b *batcher) flush() error {
+	if len(b.entries) == 0 {
+		return nil
+	}
+	if b.closed {
+		return b.wait()
+	}
+	body := b.encode(b.entries)
+	b.entries = nil
+	b.pending.Add(1)
+	select {
+	case b.batches <- body:
+		return nil
+	case <-b.ctx.Done():
+		b.pending.Done()
+		close(b.batches)
+		b.closed = true
+		return b.wait()
+	}
+}
+
+// sync sends the rest of the entries and waits until the batches sent so far are done
+func (b *batcher) sync() error {
+	if err := b.flush(); err != nil {
+		return err
+	}
+	b.pending.Wait()
+	if b.ctx.Err() == nil {
+		return nil
+	}
+	// A worker failed, so the other workers are stopped
+	if !b.closed {
+		close(b.batches)
+		b.closed = true
+	}
+	return b.wait()
+}
+
+// wait returns the error of the workers stopped by it or the cancellation
+func (b *batcher) wait() error {
+	if err := b.eg.Wait(); err != nil {
+		return err
+	}
+	return b.ctx.Err()
+}
+
+// close sends the rest of the entries and waits for the workers
+func (b *batcher) close() error {
+	var err error
+	if !b.closed {
+		err = b.flush()
+		if !b.closed {
+			close(b.batches)
+			b.closed = true
+		}
+	}
+	if werr := b.eg.Wait(); werr != nil {
+		err = werr
+	}
+	if b.deadLetter != nil {
+		if cerr := b.deadLetter.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// parseHeaders parses the headers in "Name: value" format
+func parseHeaders(headers []string) (http.Header, error) {
+	h := http.Header{}
+	for _, v := range headers {
+		splitted := strings.SplitN(v, ":", 2)
+		if len(splitted) != 2 || strings.TrimSpace(splitted[0]) == "" {
+			return nil, fmt.Errorf("invalid header: %s", v)
+		}
+		h.Add(strings.TrimSpace(splitted[0]), strings.TrimSpace(splitted[1]))
+	}
+	return h, nil
+}
+
+// retryableError is the error of the request which can be retried (429 or 5xx)
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+// post sends body to url and calls check with the response.
+// If the response status is 429 or 5xx, or check returns retryableError, it is retried with exponential backoff up to maxRetries times.
+func post(ctx context.Context, client *http.Client, url string, header http.Header, body []byte, maxRetries int, check func(res *http.Response, b []byte) error) error {
+	for i := 0; ; i++ {
+		err := postOnce(ctx, client, url, header, body, check)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(*retryableError); !ok || i >= maxRetries {
+			return err
+		}
+		d := retryBaseDelay << uint(i)
+		if d <= 0 || d > retryMaxDelay {
+			d = retryMaxDelay
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+}
+
+func postOnce(ctx context.Context, client *http.Client, url string, header http.Header, body []byte, check func(res *http.Response, b []byte) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// network errors
+		return &retryableError{err: err}
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return &retryableError{err: err}
+	}
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError {
+		return &retryableError{err: fmt.Errorf("%s: %s", res.Status, truncate(b))}
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", res.Status, truncate(b))
+	}
+	if check == nil {
+		return nil
+	}
+	return check(res, b)
+}
+
+func truncate(b []byte) string {
+	const max = 512
+	if len(b) > max {
+		return string(b[:max]) + "..."
+	}
+	return string(b)
+}
@@ -0,0 +1,228 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/pepabo/trail-digger/trail"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	parquetParallel = 4
+	// parquetPartitionRowGroupSize is the row group size of partitioned files, which are written at the same time
+	parquetPartitionRowGroupSize = 8 * 1024 * 1024
+	parquetFileName              = "events.parquet"
+)
+
+// parquetEvent is the schema of events in Parquet.
+// The nested fields are kept as JSON strings, and raw is the event as it is recorded in trail log files.
+type parquetEvent struct {
+	EventVersion        string  `parquet:"name=eventVersion, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	UserIdentity        *string `parquet:"name=userIdentity, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	EventTime           int64   `parquet:"name=eventTime, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	EventSource         string  `parquet:"name=eventSource, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	EventName           string  `parquet:"name=eventName, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	AwsRegion           string  `parquet:"name=awsRegion, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	SourceIPAddress     string  `parquet:"name=sourceIPAddress, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	UserAgent           string  `parquet:"name=userAgent, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	ErrorCode           string  `parquet:"name=errorCode, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	ErrorMessage        string  `parquet:"name=errorMessage, type=BYTE_ARRAY, convertedtype=UTF8"`
+	RequestParameters   *string `parquet:"name=requestParameters, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	ResponseElements    *string `parquet:"name=responseElements, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	AdditionalEventData *string `parquet:"name=additionalEventData, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	RequestID           string  `parquet:"name=requestID, type=BYTE_ARRAY, convertedtype=UTF8"`
+	EventID             string  `parquet:"name=eventID, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ReadOnly            bool    `parquet:"name=readOnly, type=BOOLEAN"`
+	Resources           *string `parquet:"name=resources, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	EventType           string  `parquet:"name=eventType, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	ManagementEvent     bool    `parquet:"name=managementEvent, type=BOOLEAN"`
+	RecipientAccountID  string  `parquet:"name=recipientAccountId, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	SharedEventID       string  `parquet:"name=sharedEventID, type=BYTE_ARRAY, convertedtype=UTF8"`
+	EventCategory       string  `parquet:"name=eventCategory, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Raw                 string  `parquet:"name=raw, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+func newParquetEvent(r *trail.Record) (*parquetEvent, error) {
+	raw, err := r.MarshalRaw()
+	if err != nil {
+		return nil, err
+	}
+	f, err := r.Fields()
+	if err != nil {
+		return nil, err
+	}
+	nested := func(k string) (*string, error) {
+		v, ok := f[k]
+		if !ok || v == nil {
+			return nil, nil
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		s := string(b)
+		return &s, nil
+	}
+	e := &parquetEvent{
+		EventVersion:       r.EventVersion,
+		EventTime:          r.EventTime.UnixNano() / 1000000,
+		EventSource:        r.EventSource,
+		EventName:          r.EventName,
+		AwsRegion:          r.AwsRegion,
+		SourceIPAddress:    r.SourceIPAddress,
+		UserAgent:          r.UserAgent,
+		ErrorCode:          r.ErrorCode,
+		ErrorMessage:       r.ErrorMessage,
+		RequestID:          r.RequestID,
+		EventID:            r.EventID,
+		ReadOnly:           r.ReadOnly,
+		EventType:          r.EventType,
+		ManagementEvent:    r.ManagementEvent,
+		RecipientAccountID: r.RecipientAccountID,
+		SharedEventID:      r.SharedEventID,
+		EventCategory:      r.EventCategory,
+		Raw:                string(raw),
+	}
+	for k, p := range map[string]**string{
+		"userIdentity":        &e.UserIdentity,
+		"requestParameters":   &e.RequestParameters,
+		"responseElements":    &e.ResponseElements,
+		"additionalEventData": &e.AdditionalEventData,
+		"resources":           &e.Resources,
+	} {
+		if *p, err = nested(k); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+type parquetFile struct {
+	f  *os.File
+	pw *writer.ParquetWriter
+}
+
+func newParquetFile(p string, rowGroupSize int64) (*parquetFile, error) {
+	f, err := os.Create(filepath.Clean(p))
+	if err != nil {
+		return nil, err
+	}
+	pw, err := writer.NewParquetWriterFromWriter(f, new(parquetEvent), parquetParallel)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if rowGroupSize > 0 {
+		pw.RowGroupSize = rowGroupSize
+	}
+	return &parquetFile{f: f, pw: pw}, nil
+}
+
+func (pf *parquetFile) close() error {
+	if err := pf.pw.WriteStop(); err != nil {
+		_ = pf.f.Close()
+		return err
+	}
+	return pf.f.Close()
+}
+
+type parquetSink struct {
+	// dir is the directory to write files partitioned by date, account and region. If it is empty, events are written to file
+	dir   string
+	file  *parquetFile
+	files map[string]*parquetFile
+	day   string
+}
+
+// NewParquet returns the Sink writing events to the Parquet file.
+// If output is a directory (or ends with /), events are written to the files partitioned by date, account and region
+// (eg. date=2022-02-03/account=1234567890/region=ap-northeast-1/events.parquet).
+func NewParquet(output string) (Sink, error) {
+	fi, err := os.Stat(output)
+	if strings.HasSuffix(output, "/") || (err == nil && fi.IsDir()) {
+		if err := os.MkdirAll(output, 0750); err != nil {
+			return nil, err
+		}
+		return &parquetSink{dir: output, files: map[string]*parquetFile{}}, nil
+	}
+	pf, err := newParquetFile(output, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &parquetSink{file: pf}, nil
+}
+
+func (s *parquetSink) Write(r *trail.Record) error {
+	e, err := newParquetEvent(r)
+	if err != nil {
+		return err
+	}
+	if s.dir == "" {
+		return s.file.pw.Write(e)
+	}
+	pf, err := s.partition(r)
+	if err != nil {
+		return err
+	}
+	return pf.pw.Write(e)
+}
+
+// partition returns the file of the partition of the event.
+// Events are walked in order of timeline, so the files of the previous days are closed.
+func (s *parquetSink) partition(r *trail.Record) (*parquetFile, error) {
+	day := r.EventTime.UTC().Format("2006-01-02")
+	if day != s.day {
+		if err := s.closePartitions(); err != nil {
+			return nil, err
+		}
+		s.day = day
+	}
+	account := r.RecipientAccountID
+	if account == "" {
+		account = "unknown"
+	}
+	region := r.AwsRegion
+	if region == "" {
+		region = "unknown"
+	}
+	p := filepath.Join(s.dir, fmt.Sprintf("date=%s", day), fmt.Sprintf("account=%s", account), fmt.Sprintf("region=%s", region))
+	if pf, ok := s.files[p]; ok {
+		return pf, nil
+	}
+	if err := os.MkdirAll(p, 0750); err != nil {
+		return nil, err
+	}
+	pf, err := newParquetFile(filepath.Join(p, parquetFileName), parquetPartitionRowGroupSize)
+	if err != nil {
+		return nil, err
+	}
+	s.files[p] = pf
+	return pf, nil
+}
+
+func (s *parquetSink) closePartitions() error {
+	var err error
+	for p, pf := range s.files {
+		if e := pf.close(); e != nil && err == nil {
+			err = e
+		}
+		delete(s.files, p)
+	}
+	return err
+}
+
+// Flush does nothing because Parquet files are readable only after the footer is written on close
+func (s *parquetSink) Flush() error {
+	return nil
+}
+
+func (s *parquetSink) Close() error {
+	if s.dir == "" {
+		return s.file.close()
+	}
+	return s.closePartitions()
+}
@@ -0,0 +1,107 @@
+package sink
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pepabo/trail-digger/trail"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+var parquetTestRecords = []string{
+	`{"eventTime":"2022-02-03T14:05:00Z","eventID":"a","eventName":"PutObject","recipientAccountId":"123456789012","awsRegion":"ap-northeast-1","userIdentity":{"arn":"arn:aws:iam::123456789012:user/alice"},"resources":[{"ARN":"arn:aws:s3:::bucket/key"}],"readOnly":false}`,
+	`{"eventTime":"2022-02-03T14:06:00Z","eventID":"b","eventName":"ListUsers","recipientAccountId":"123456789012","awsRegion":"us-east-1","userIdentity":{"arn":"arn:aws:iam::123456789012:user/bob"},"readOnly":true}`,
+	`{"eventTime":"2022-02-04T00:01:00Z","eventID":"c","eventName":"ListUsers","recipientAccountId":"210987654321","awsRegion":"us-east-1","readOnly":true}`,
+}
+
+func writeParquet(t *testing.T, output string) {
+	t.Helper()
+	s, err := NewParquet(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range parquetTestRecords {
+		r := &trail.Record{}
+		if err := r.UnmarshalJSON([]byte(rec)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readParquet(t *testing.T, p string) []parquetEvent {
+	t.Helper()
+	f, err := local.NewLocalFileReader(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	pr, err := reader.NewParquetReader(f, new(parquetEvent), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+	events := make([]parquetEvent, pr.GetNumRows())
+	if err := pr.Read(&events); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestParquet(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "events.parquet")
+	writeParquet(t, p)
+	events := readParquet(t, p)
+	got := []string{}
+	for _, e := range events {
+		got = append(got, e.EventID)
+	}
+	if diff := cmp.Diff(got, []string{"a", "b", "c"}, nil); diff != "" {
+		t.Errorf("%s", diff)
+	}
+	e := events[0]
+	if e.EventTime != 1643897100000 {
+		t.Errorf("got %v want %v", e.EventTime, 1643897100000)
+	}
+	if e.UserIdentity == nil || *e.UserIdentity != `{"arn":"arn:aws:iam::123456789012:user/alice"}` {
+		t.Errorf("got %v", e.UserIdentity)
+	}
+	if e.Resources == nil || *e.Resources != `[{"ARN":"arn:aws:s3:::bucket/key"}]` {
+		t.Errorf("got %v", e.Resources)
+	}
+	if e.Raw != parquetTestRecords[0] {
+		t.Errorf("got %v want %v", e.Raw, parquetTestRecords[0])
+	}
+	if events[2].UserIdentity != nil {
+		t.Errorf("got %v want nil", *events[2].UserIdentity)
+	}
+}
+
+func TestParquetPartitioned(t *testing.T) {
+	dir := t.TempDir()
+	writeParquet(t, dir+"/")
+	tests := []struct {
+		partition string
+		want      []string
+	}{
+		{"date=2022-02-03/account=123456789012/region=ap-northeast-1", []string{"a"}},
+		{"date=2022-02-03/account=123456789012/region=us-east-1", []string{"b"}},
+		{"date=2022-02-04/account=210987654321/region=us-east-1", []string{"c"}},
+	}
+	for _, tt := range tests {
+		got := []string{}
+		for _, e := range readParquet(t, filepath.Join(dir, filepath.FromSlash(tt.partition), parquetFileName)) {
+			got = append(got, e.EventID)
+		}
+		if diff := cmp.Diff(got, tt.want, nil); diff != "" {
+			t.Errorf("%s: %s", tt.partition, diff)
+		}
+	}
+}
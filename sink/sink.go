@@ -0,0 +1,157 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pepabo/trail-digger/trail"
+)
+
+// DefaultColumns are the columns of CSV and TSV if no column is specified
+var DefaultColumns = []string{"eventTime", "eventSource", "eventName", "awsRegion", "userIdentity.arn", "sourceIPAddress", "errorCode"}
+
+// Sink is the destination of events
+type Sink interface {
+	// Write writes the event
+	Write(r *trail.Record) error
+	// Flush writes the buffered events to the destination (eg. before saving the checkpoint)
+	Flush() error
+	// Close flushes the buffered events and releases the resources of the sink
+	Close() error
+}
+
+type Option struct {
+	// Format is the format of events (json, csv, tsv, parquet or sqlite)
+	Format string
+	// Output is the file (or the URL of the remote sink such as es://host:9200/index-prefix, splunk://host:8088 and https://example.com/webhook) to write events.
+	// If it is empty, events are written to Stdout
+	Output string
+	// Columns are the dotted paths of the fields written as columns of csv and tsv (eg. userIdentity.arn)
+	Columns []string
+	Stdout  io.Writer
+	// BatchSize is the number of events sent in a request to the remote sink (eg. Elasticsearch)
+	BatchSize int
+	// Workers is the number of concurrent requests to the remote sink
+	Workers int
+	// Headers are the additional headers of requests to the remote sink in "Name: value" format
+	Headers []string
+	// Gzip compresses the request bodies to the remote sink
+	Gzip bool
+	// DeadLetter is the file to append the request bodies which still fail after retries.
+	// If it is empty, the failure is returned as an error
+	DeadLetter string
+	// Append appends events to the existing Output instead of truncating it (eg. resuming from a checkpoint).
+	// Parquet files can not be appended
+	Append bool
+}
+
+// New returns the Sink for opt
+func New(ctx context.Context, opt Option) (Sink, error) {
+	switch {
+	case IsElasticsearch(opt.Output):
+		return NewElasticsearch(ctx, opt)
+	case IsSplunk(opt.Output):
+		return NewSplunk(ctx, opt)
+	case IsWebhook(opt.Output):
+		return NewWebhook(ctx, opt)
+	}
+	switch opt.Format {
+	case "parquet", "sqlite":
+		if opt.Output == "" {
+			return nil, fmt.Errorf("output is required for format %s", opt.Format)
+		}
+		// SQLite databases are always appended because events are inserted into the existing table
+		if opt.Format == "sqlite" {
+			return NewSQLite(opt.Output)
+		}
+		if opt.Append {
+			return nil, fmt.Errorf("format %s can not append events to the existing output", opt.Format)
+		}
+		return NewParquet(opt.Output)
+	}
+	// header is true if the header row of csv and tsv has already been written to the output
+	var fn func(w io.Writer, header bool) Sink
+	switch opt.Format {
+	case "", "json":
+		fn = func(w io.Writer, _ bool) Sink { return NewJSON(w) }
+	case "csv":
+		fn = func(w io.Writer, header bool) Sink { return newCSV(w, opt.Columns, ',', header) }
+	case "tsv":
+		fn = func(w io.Writer, header bool) Sink { return newCSV(w, opt.Columns, '\t', header) }
+	default:
+		return nil, fmt.Errorf("unsupported format: %s", opt.Format)
+	}
+	if opt.Output == "" {
+		return fn(opt.Stdout, false), nil
+	}
+	if !opt.Append {
+		f, err := os.Create(filepath.Clean(opt.Output))
+		if err != nil {
+			return nil, err
+		}
+		return &fileSink{Sink: fn(f, false), f: f}, nil
+	}
+	f, err := os.OpenFile(filepath.Clean(opt.Output), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &fileSink{Sink: fn(f, fi.Size() > 0), f: f}, nil
+}
+
+// fileSink closes the file after the sink writing to it
+type fileSink struct {
+	Sink
+	f *os.File
+}
+
+func (s *fileSink) Flush() error {
+	if err := s.Sink.Flush(); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *fileSink) Close() error {
+	if err := s.Sink.Close(); err != nil {
+		_ = s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+type jsonSink struct {
+	w *bufio.Writer
+}
+
+// NewJSON returns the Sink writing events as JSONL as they are recorded in trail log files
+func NewJSON(w io.Writer) Sink {
+	return &jsonSink{w: bufio.NewWriter(w)}
+}
+
+func (s *jsonSink) Write(r *trail.Record) error {
+	b, err := r.MarshalRaw()
+	if err != nil {
+		return err
+	}
+	if _, err := s.w.Write(b); err != nil {
+		return err
+	}
+	return s.w.WriteByte('\n')
+}
+
+func (s *jsonSink) Flush() error {
+	return s.w.Flush()
+}
+
+func (s *jsonSink) Close() error {
+	return s.w.Flush()
+}
@@ -0,0 +1,130 @@
+package sink
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pepabo/trail-digger/trail"
+)
+
+func TestNewAppend(t *testing.T) {
+	records := []string{
+		`{"eventTime":"2022-02-03T14:05:00Z","eventID":"a","eventName":"PutObject"}`,
+		`{"eventTime":"2022-02-03T14:06:00Z","eventID":"b","eventName":"CreateUser"}`,
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"json", records[0] + "\n" + records[1] + "\n"},
+		{"csv", "eventID,eventName\na,PutObject\nb,CreateUser\n"},
+	}
+	for _, tt := range tests {
+		out := filepath.Join(t.TempDir(), "events")
+		// the first run and the run resumed from the checkpoint
+		for i, rec := range records {
+			s, err := New(context.Background(), Option{Format: tt.format, Output: out, Columns: []string{"eventID", "eventName"}, Append: i > 0})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := &trail.Record{}
+			if err := r.UnmarshalJSON([]byte(rec)); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Write(r); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		b, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(string(b), tt.want, nil); diff != "" {
+			t.Errorf("%s: %s", tt.format, diff)
+		}
+	}
+
+	if _, err := New(context.Background(), Option{Format: "parquet", Output: filepath.Join(t.TempDir(), "events.parquet"), Append: true}); err == nil {
+		t.Error("want error")
+	}
+}
+
+func TestFlush(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received int
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		received += 1
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+	lines := func(p string) func() int {
+		return func() int {
+			b, err := os.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return strings.Count(string(b), "\n")
+		}
+	}
+	rows := func(p string) func() int {
+		return func() int {
+			db, err := sql.Open("sqlite", p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			var n int
+			if err := db.QueryRow(`SELECT count(*) FROM events`).Scan(&n); err != nil {
+				t.Fatal(err)
+			}
+			return n
+		}
+	}
+	tests := []struct {
+		opt     Option
+		flushed func() int
+		want    int
+	}{
+		{Option{Format: "json", Output: filepath.Join(dir, "events.jsonl")}, lines(filepath.Join(dir, "events.jsonl")), 1},
+		{Option{Format: "csv", Output: filepath.Join(dir, "events.csv")}, lines(filepath.Join(dir, "events.csv")), 2},
+		{Option{Format: "sqlite", Output: filepath.Join(dir, "events.db")}, rows(filepath.Join(dir, "events.db")), 1},
+		{Option{Output: ts.URL}, func() int { mu.Lock(); defer mu.Unlock(); return received }, 1},
+	}
+	for _, tt := range tests {
+		s, err := New(context.Background(), tt.opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := &trail.Record{}
+		if err := r.UnmarshalJSON([]byte(`{"eventTime":"2022-02-03T14:05:00Z","eventID":"a","eventName":"PutObject"}`)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Write(r); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if got := tt.flushed(); got != tt.want {
+			t.Errorf("%s: got %v want %v before close", tt.opt.Output, got, tt.want)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
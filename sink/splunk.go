@@ -0,0 +1,103 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/pepabo/trail-digger/trail"
+)
+
+const defaultSplunkSourcetype = "aws:cloudtrail"
+
+type splunkSink struct {
+	index      string
+	source     string
+	sourcetype string
+	b          *batcher
+}
+
+// hecEvent is the event of Splunk HTTP Event Collector
+type hecEvent struct {
+	Time       json.RawMessage `json:"time"`
+	Index      string          `json:"index,omitempty"`
+	Source     string          `json:"source,omitempty"`
+	Sourcetype string          `json:"sourcetype"`
+	Event      json.RawMessage `json:"event"`
+}
+
+// IsSplunk reports whether output is the URL of Splunk HTTP Event Collector
+func IsSplunk(output string) bool {
+	return strings.HasPrefix(output, "splunk://") || strings.HasPrefix(output, "splunk+https://")
+}
+
+// NewSplunk returns the Sink sending events to Splunk HTTP Event Collector.
+// opt.Output is splunk://host:8088 (or splunk+https://token@host:8088?index=main&sourcetype=aws:cloudtrail&source=trail-digger),
+// and eventTime is sent as the time of the event.
+// The token can also be specified as the header (eg. "Authorization: Splunk <token>").
+func NewSplunk(ctx context.Context, opt Option) (Sink, error) {
+	u, err := url.Parse(opt.Output)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" || strings.Trim(u.Path, "/") != "" {
+		return nil, fmt.Errorf("invalid splunk url: %s", opt.Output)
+	}
+	scheme := "http"
+	if u.Scheme == "splunk+https" {
+		scheme = "https"
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	if u.User != nil {
+		header.Set("Authorization", fmt.Sprintf("Splunk %s", u.User.Username()))
+	}
+	q := u.Query()
+	s := &splunkSink{
+		index:      q.Get("index"),
+		source:     q.Get("source"),
+		sourcetype: q.Get("sourcetype"),
+	}
+	if s.sourcetype == "" {
+		s.sourcetype = defaultSplunkSourcetype
+	}
+	s.b, err = newBatcher(ctx, fmt.Sprintf("%s://%s/services/collector/event", scheme, u.Host), header, opt, func(entries [][]byte) []byte {
+		return bytes.Join(entries, []byte{'\n'})
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *splunkSink) Write(r *trail.Record) error {
+	raw, err := r.MarshalRaw()
+	if err != nil {
+		return err
+	}
+	ms := r.EventTime.UnixNano() / 1000000
+	b, err := json.Marshal(&hecEvent{
+		// epoch time in seconds with milliseconds
+		Time:       json.RawMessage(fmt.Sprintf("%d.%03d", ms/1000, ms%1000)),
+		Index:      s.index,
+		Source:     s.source,
+		Sourcetype: s.sourcetype,
+		Event:      raw,
+	})
+	if err != nil {
+		return err
+	}
+	return s.b.add(b)
+}
+
+func (s *splunkSink) Flush() error {
+	return s.b.sync()
+}
+
+func (s *splunkSink) Close() error {
+	return s.b.close()
+}
@@ -0,0 +1,83 @@
+package sink
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pepabo/trail-digger/trail"
+)
+
+func TestSplunk(t *testing.T) {
+	records := []string{
+		`{"eventID":"e1","eventTime":"2022-02-03T14:05:00.123Z","eventName":"PutObject"}`,
+		`{"eventID":"e2","eventTime":"2022-02-03T14:06:00Z","eventName":"GetObject"}`,
+	}
+	var (
+		mu     sync.Mutex
+		bodies []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != "/services/collector/event" {
+			t.Errorf("invalid path: %s", r.URL.Path)
+		}
+		if got, want := r.Header.Get("Authorization"), "Splunk token"; got != want {
+			t.Errorf("got %v want %v", got, want)
+		}
+		if got, want := r.Header.Get("X-Test"), "a, b"; got != want {
+			t.Errorf("got %v want %v", got, want)
+		}
+		if got, want := r.Header.Get("Content-Encoding"), "gzip"; got != want {
+			t.Errorf("got %v want %v", got, want)
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies = append(bodies, string(b))
+		_, _ = io.WriteString(w, `{"text":"Success","code":0}`)
+	}))
+	defer ts.Close()
+
+	s, err := NewSplunk(context.Background(), Option{
+		Output:    fmt.Sprintf("splunk://token@%s?index=main", strings.TrimPrefix(ts.URL, "http://")),
+		BatchSize: 10,
+		Headers:   []string{"X-Test: a, b"},
+		Gzip:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range records {
+		r := &trail.Record{}
+		if err := r.UnmarshalJSON([]byte(rec)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`{"time":1643897100.123,"index":"main","sourcetype":"aws:cloudtrail","event":{"eventID":"e1","eventTime":"2022-02-03T14:05:00.123Z","eventName":"PutObject"}}` + "\n" +
+			`{"time":1643897160.000,"index":"main","sourcetype":"aws:cloudtrail","event":{"eventID":"e2","eventTime":"2022-02-03T14:06:00Z","eventName":"GetObject"}}`,
+	}
+	if diff := cmp.Diff(bodies, want, nil); diff != "" {
+		t.Errorf("%s", diff)
+	}
+}
@@ -0,0 +1,154 @@
+package sink
+
+import (
+	"database/sql"
+
+	"github.com/pepabo/trail-digger/trail"
+	_ "modernc.org/sqlite"
+)
+
+const sqliteBatchSize = 1000
+
+// sqliteTimeFormat is the fixed-width format of event_time in UTC, so that the text is sorted in order of time
+const sqliteTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS events (
+  event_id TEXT PRIMARY KEY,
+  event_time TEXT NOT NULL,
+  event_version TEXT,
+  event_source TEXT,
+  event_name TEXT,
+  aws_region TEXT,
+  source_ip_address TEXT,
+  user_agent TEXT,
+  user_identity_type TEXT,
+  user_identity_arn TEXT,
+  user_identity_account_id TEXT,
+  user_identity_principal_id TEXT,
+  user_identity_access_key_id TEXT,
+  user_identity_user_name TEXT,
+  error_code TEXT,
+  error_message TEXT,
+  request_id TEXT,
+  read_only INTEGER,
+  event_type TEXT,
+  management_event INTEGER,
+  recipient_account_id TEXT,
+  shared_event_id TEXT,
+  event_category TEXT,
+  raw TEXT NOT NULL
+)`,
+	`CREATE INDEX IF NOT EXISTS events_event_time ON events (event_time)`,
+	`CREATE INDEX IF NOT EXISTS events_event_source ON events (event_source, event_name)`,
+	`CREATE INDEX IF NOT EXISTS events_event_name ON events (event_name)`,
+	`CREATE INDEX IF NOT EXISTS events_user_identity_arn ON events (user_identity_arn)`,
+	`CREATE INDEX IF NOT EXISTS events_source_ip_address ON events (source_ip_address)`,
+}
+
+const sqliteInsert = `INSERT OR REPLACE INTO events (
+  event_id, event_time, event_version, event_source, event_name, aws_region, source_ip_address, user_agent,
+  user_identity_type, user_identity_arn, user_identity_account_id, user_identity_principal_id, user_identity_access_key_id, user_identity_user_name,
+  error_code, error_message, request_id, read_only, event_type, management_event, recipient_account_id, shared_event_id, event_category, raw
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+type sqliteSink struct {
+	db   *sql.DB
+	tx   *sql.Tx
+	stmt *sql.Stmt
+	n    int
+}
+
+// NewSQLite returns the Sink inserting events into the events table of the SQLite database file.
+// Events are inserted in batches, and the event with the same eventID is replaced.
+func NewSQLite(output string) (Sink, error) {
+	db, err := sql.Open("sqlite", output)
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range sqliteSchema {
+		if _, err := db.Exec(q); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	return &sqliteSink{db: db}, nil
+}
+
+func (s *sqliteSink) Write(r *trail.Record) error {
+	if s.tx == nil {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		stmt, err := tx.Prepare(sqliteInsert)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		s.tx = tx
+		s.stmt = stmt
+	}
+	raw, err := r.MarshalRaw()
+	if err != nil {
+		return err
+	}
+	if _, err := s.stmt.Exec(
+		r.EventID,
+		r.EventTime.UTC().Format(sqliteTimeFormat),
+		r.EventVersion,
+		r.EventSource,
+		r.EventName,
+		r.AwsRegion,
+		r.SourceIPAddress,
+		r.UserAgent,
+		r.UserIdentity.Type,
+		r.UserIdentity.Arn,
+		r.UserIdentity.AccountID,
+		r.UserIdentity.PrincipalID,
+		r.UserIdentity.AccessKeyID,
+		r.UserIdentity.UserName,
+		r.ErrorCode,
+		r.ErrorMessage,
+		r.RequestID,
+		r.ReadOnly,
+		r.EventType,
+		r.ManagementEvent,
+		r.RecipientAccountID,
+		r.SharedEventID,
+		r.EventCategory,
+		string(raw),
+	); err != nil {
+		return err
+	}
+	s.n += 1
+	if s.n < sqliteBatchSize {
+		return nil
+	}
+	return s.commit()
+}
+
+func (s *sqliteSink) commit() error {
+	if s.tx == nil {
+		return nil
+	}
+	_ = s.stmt.Close()
+	err := s.tx.Commit()
+	s.tx = nil
+	s.stmt = nil
+	s.n = 0
+	return err
+}
+
+// Flush commits the events inserted in the current batch
+func (s *sqliteSink) Flush() error {
+	return s.commit()
+}
+
+func (s *sqliteSink) Close() error {
+	if err := s.commit(); err != nil {
+		_ = s.db.Close()
+		return err
+	}
+	return s.db.Close()
+}
@@ -0,0 +1,118 @@
+package sink
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pepabo/trail-digger/trail"
+)
+
+func TestSQLite(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "trail.db")
+	d := time.Date(2022, 2, 3, 14, 5, 0, 0, time.UTC)
+	n := sqliteBatchSize + 10
+	// written twice to check that reruns are idempotent
+	for i := 0; i < 2; i++ {
+		s, err := NewSQLite(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < n; j++ {
+			r := &trail.Record{}
+			rec := fmt.Sprintf(`{"eventTime":"%s","eventID":"%05d","eventSource":"iam.amazonaws.com","eventName":"ListUsers","userIdentity":{"arn":"arn:aws:iam::123456789012:user/alice"},"sourceIPAddress":"192.0.2.1","readOnly":true}`, d.Add(time.Duration(j)*time.Second).Format(time.RFC3339), j)
+			if err := r.UnmarshalJSON([]byte(rec)); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Write(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var count int
+	if err := db.QueryRow(`SELECT count(*) FROM events WHERE user_identity_arn = ? AND read_only = 1`, "arn:aws:iam::123456789012:user/alice").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != n {
+		t.Errorf("got %v want %v", count, n)
+	}
+	var (
+		id, et, raw string
+	)
+	if err := db.QueryRow(`SELECT event_id, event_time, raw FROM events ORDER BY event_time DESC LIMIT 1`).Scan(&id, &et, &raw); err != nil {
+		t.Fatal(err)
+	}
+	got := []string{id, et}
+	want := []string{fmt.Sprintf("%05d", n-1), d.Add(time.Duration(n-1) * time.Second).Format("2006-01-02T15:04:05.000000000Z")}
+	if diff := cmp.Diff(got, want, nil); diff != "" {
+		t.Errorf("%s", diff)
+	}
+	r := &trail.Record{}
+	if err := r.UnmarshalJSON([]byte(raw)); err != nil {
+		t.Fatal(err)
+	}
+	if r.EventID != id {
+		t.Errorf("got %v want %v", r.EventID, id)
+	}
+}
+
+func TestSQLiteEventTimeOrder(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "trail.db")
+	s, err := NewSQLite(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range []string{
+		`{"eventTime":"2022-02-03T14:05:00.5Z","eventID":"b"}`,
+		`{"eventTime":"2022-02-03T14:05:00Z","eventID":"a"}`,
+		`{"eventTime":"2022-02-03T23:05:01+09:00","eventID":"c"}`,
+	} {
+		r := &trail.Record{}
+		if err := r.UnmarshalJSON([]byte(rec)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query(`SELECT event_id FROM events WHERE event_time >= '2022-02-03T14:05:00.000000000Z' ORDER BY event_time`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	got := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, []string{"a", "b", "c"}, nil); diff != "" {
+		t.Errorf("%s", diff)
+	}
+}
@@ -0,0 +1,67 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/pepabo/trail-digger/trail"
+)
+
+type webhookSink struct {
+	b *batcher
+}
+
+// webhookEvent is the event posted to the webhook
+type webhookEvent struct {
+	Timestamp string          `json:"timestamp"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// IsWebhook reports whether output is the URL of the HTTP endpoint
+func IsWebhook(output string) bool {
+	return strings.HasPrefix(output, "http://") || strings.HasPrefix(output, "https://")
+}
+
+// NewWebhook returns the Sink posting events to the HTTP endpoint.
+// Each request body is the JSON array of the batch of events (eg. [{"timestamp":"2022-02-03T14:05:00Z","event":{...}}]),
+// and timestamp is eventTime of the event.
+func NewWebhook(ctx context.Context, opt Option) (Sink, error) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	b, err := newBatcher(ctx, opt.Output, header, opt, func(entries [][]byte) []byte {
+		body := []byte{'['}
+		body = append(body, bytes.Join(entries, []byte{','})...)
+		return append(body, ']')
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &webhookSink{b: b}, nil
+}
+
+func (s *webhookSink) Write(r *trail.Record) error {
+	raw, err := r.MarshalRaw()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(&webhookEvent{
+		Timestamp: r.EventTime.UTC().Format(time.RFC3339Nano),
+		Event:     raw,
+	})
+	if err != nil {
+		return err
+	}
+	return s.b.add(b)
+}
+
+func (s *webhookSink) Flush() error {
+	return s.b.sync()
+}
+
+func (s *webhookSink) Close() error {
+	return s.b.close()
+}
@@ -0,0 +1,89 @@
+package sink
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pepabo/trail-digger/trail"
+)
+
+func TestWebhook(t *testing.T) {
+	records := []string{
+		`{"eventID":"e1","eventTime":"2022-02-03T14:05:00Z","eventName":"PutObject"}`,
+		`{"eventID":"e2","eventTime":"2022-02-03T14:06:00Z","eventName":"GetObject"}`,
+		`{"eventID":"e3","eventTime":"2022-02-03T14:07:00Z","eventName":"DeleteObject"}`,
+	}
+	var (
+		mu     sync.Mutex
+		bodies []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			// rejected without retries, and written to the dead-letter file
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		bodies = append(bodies, string(b))
+	}))
+	defer ts.Close()
+
+	write := func(opt Option) error {
+		s, err := NewWebhook(context.Background(), opt)
+		if err != nil {
+			return err
+		}
+		for _, rec := range records {
+			r := &trail.Record{}
+			if err := r.UnmarshalJSON([]byte(rec)); err != nil {
+				return err
+			}
+			if err := s.Write(r); err != nil {
+				return err
+			}
+		}
+		return s.Close()
+	}
+
+	if err := write(Option{Output: ts.URL, BatchSize: 2, Workers: 1, Headers: []string{"Authorization: Bearer token"}}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`[{"timestamp":"2022-02-03T14:05:00Z","event":{"eventID":"e1","eventTime":"2022-02-03T14:05:00Z","eventName":"PutObject"}},` +
+			`{"timestamp":"2022-02-03T14:06:00Z","event":{"eventID":"e2","eventTime":"2022-02-03T14:06:00Z","eventName":"GetObject"}}]`,
+		`[{"timestamp":"2022-02-03T14:07:00Z","event":{"eventID":"e3","eventTime":"2022-02-03T14:07:00Z","eventName":"DeleteObject"}}]`,
+	}
+	if diff := cmp.Diff(bodies, want, nil); diff != "" {
+		t.Errorf("%s", diff)
+	}
+
+	// failure without the dead-letter file
+	if err := write(Option{Output: ts.URL, BatchSize: 2, Workers: 1}); err == nil {
+		t.Error("want error")
+	}
+
+	// failure with the dead-letter file
+	dl := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	if err := write(Option{Output: ts.URL, BatchSize: 2, Workers: 1, DeadLetter: dl}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(dl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(b), want[0]+"\n"+want[1]+"\n", nil); diff != "" {
+		t.Errorf("%s", diff)
+	}
+}
@@ -0,0 +1,187 @@
+package trail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// Checkpoint is the progress of WalkEvents to resume it
+type Checkpoint struct {
+	DSN string `json:"dsn"`
+	// Option is the options selecting the events walked. The progress is only valid for the same options
+	Option *CheckpointOption `json:"option,omitempty"`
+	// Days are the days (2006/01/02) whose events have all been walked
+	Days []string `json:"days"`
+	// Day is the day in progress and Offset is the number of events walked on the day
+	Day    string `json:"day,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+	// State is the state of the consumer of events (eg. the counts of `trail-digger analyze`)
+	State json.RawMessage `json:"state,omitempty"`
+
+	path      string
+	stateFunc func() (interface{}, error)
+	flushFunc func() error
+}
+
+// CheckpointOption is the options of WalkEvents selecting the events walked
+type CheckpointOption struct {
+	DatePath      string        `json:"date,omitempty"`
+	StartDatePath string        `json:"startDate,omitempty"`
+	EndDatePath   string        `json:"endDate,omitempty"`
+	Since         *time.Time    `json:"since,omitempty"`
+	Until         *time.Time    `json:"until,omitempty"`
+	DeliveryDelay time.Duration `json:"deliveryDelay,omitempty"`
+	Accounts      []string      `json:"accounts,omitempty"`
+	Regions       []string      `json:"regions,omitempty"`
+	AllAccounts   bool          `json:"allAccounts,omitempty"`
+	AllRegions    bool          `json:"allRegions,omitempty"`
+	Organization  string        `json:"organization,omitempty"`
+	Filter        string        `json:"filter,omitempty"`
+}
+
+func newCheckpointOption(opt Option) *CheckpointOption {
+	o := &CheckpointOption{
+		Accounts:     sortedCopy(opt.Accounts),
+		Regions:      sortedCopy(opt.Regions),
+		AllAccounts:  opt.AllAccounts,
+		AllRegions:   opt.AllRegions,
+		Organization: opt.Organization,
+		Filter:       opt.Filter,
+		// The trail log files of the following day of the date paths are pruned with it too
+		DeliveryDelay: opt.DeliveryDelay,
+	}
+	// Since takes precedence over the date paths
+	if opt.Since.IsZero() {
+		o.DatePath = opt.DatePath
+		o.StartDatePath = opt.StartDatePath
+		o.EndDatePath = opt.EndDatePath
+		return o
+	}
+	since := opt.Since.UTC()
+	o.Since = &since
+	if !opt.Until.IsZero() {
+		until := opt.Until.UTC()
+		o.Until = &until
+	}
+	return o
+}
+
+func sortedCopy(s []string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	c := append([]string{}, s...)
+	sort.Strings(c)
+	return c
+}
+
+func (o *CheckpointOption) String() string {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// LoadCheckpoint loads the checkpoint file. If the file does not exist, it returns a new checkpoint.
+// It returns an error if the checkpoint file is for another DSN or other options selecting events.
+func LoadCheckpoint(path, dsn string, opt Option) (*Checkpoint, error) {
+	o := newCheckpointOption(opt)
+	b, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Checkpoint{DSN: dsn, Option: o, Days: []string{}, path: path}, nil
+		}
+		return nil, err
+	}
+	c := &Checkpoint{Days: []string{}, path: path}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file %s: %w", path, err)
+	}
+	if c.DSN != dsn {
+		return nil, fmt.Errorf("checkpoint file %s is for %s, not %s", path, c.DSN, dsn)
+	}
+	if c.Option == nil || c.Option.String() != o.String() {
+		return nil, fmt.Errorf("checkpoint file %s is for other options %s, not %s", path, c.Option, o)
+	}
+	return c, nil
+}
+
+// Resumed reports whether the checkpoint has the progress of the previous run
+func (c *Checkpoint) Resumed() bool {
+	return len(c.Days) > 0 || c.Day != ""
+}
+
+// SetStateFunc sets the function to get the state of the consumer of events saved with the progress
+func (c *Checkpoint) SetStateFunc(fn func() (interface{}, error)) {
+	c.stateFunc = fn
+}
+
+// SetFlushFunc sets the function to flush the events walked to the destination before saving the progress,
+// so that the events marked as walked are not lost on crash
+func (c *Checkpoint) SetFlushFunc(fn func() error) {
+	c.flushFunc = fn
+}
+
+// Save flushes the events walked and writes the checkpoint file
+func (c *Checkpoint) Save() error {
+	if c.flushFunc != nil {
+		if err := c.flushFunc(); err != nil {
+			return err
+		}
+	}
+	if c.stateFunc != nil {
+		s, err := c.stateFunc()
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		c.State = b
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("%s.tmp", c.path)
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+func (c *Checkpoint) isDone(day string) bool {
+	for _, d := range c.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Checkpoint) offset(day string) int {
+	if c.Day != day {
+		return 0
+	}
+	return c.Offset
+}
+
+func (c *Checkpoint) progress(day string, offset int) {
+	c.Day = day
+	c.Offset = offset
+}
+
+func (c *Checkpoint) done(day string) {
+	if !c.isDone(day) {
+		c.Days = append(c.Days, day)
+	}
+	c.Day = ""
+	c.Offset = 0
+}
@@ -0,0 +1,144 @@
+package trail
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWalkEventsCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	dsn := "file://" + dir
+	d := time.Date(2022, 2, 3, 0, 0, 0, 0, time.UTC)
+	writeTrailLog(t, dir, "123456789012", "ap-northeast-1", d.Add(1*time.Hour), testRecord("a", d.Add(50*time.Minute)), testRecord("b", d.Add(55*time.Minute)))
+	writeTrailLog(t, dir, "123456789012", "ap-northeast-1", d.Add(24*time.Hour+5*time.Minute), testRecord("c", d.Add(23*time.Hour+59*time.Minute)), testRecord("d", d.Add(24*time.Hour+1*time.Minute)))
+	writeTrailLog(t, dir, "123456789012", "ap-northeast-1", d.Add(48*time.Hour+5*time.Minute), testRecord("e", d.Add(47*time.Hour)), testRecord("f", d.Add(48*time.Hour+1*time.Minute)))
+	opt := Option{StartDatePath: "2022/02/03", EndDatePath: "2022/02/05"}
+	want := []string{"a", "b", "c", "d", "e", "f"}
+	cpf := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	errStop := errors.New("stop")
+	got := []string{}
+	for _, stopAt := range []string{"b", "e", ""} {
+		cp, err := LoadCheckpoint(cpf, dsn, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opt.Checkpoint = cp
+		if err := WalkEvents(nil, dsn, opt, func(r *Record) error {
+			if r.EventID == stopAt {
+				return errStop
+			}
+			got = append(got, r.EventID)
+			return nil
+		}); err != nil && !errors.Is(err, errStop) {
+			t.Fatal(err)
+		}
+	}
+	if diff := cmp.Diff(got, want, nil); diff != "" {
+		t.Errorf("%s", diff)
+	}
+
+	cp, err := LoadCheckpoint(cpf, dsn, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(cp.Days, []string{"2022/02/03", "2022/02/04", "2022/02/05", "2022/02/06"}, nil); diff != "" {
+		t.Errorf("%s", diff)
+	}
+	if _, err := LoadCheckpoint(cpf, "file:///other", opt); err == nil {
+		t.Error("want error")
+	}
+}
+
+func TestLoadCheckpointOption(t *testing.T) {
+	dsn := "file:///trail"
+	opt := Option{
+		DatePath: "2022/02/03",
+		Accounts: []string{"123456789012", "210987654321"},
+		Filter:   `eventSource == "iam.amazonaws.com"`,
+	}
+	cpf := filepath.Join(t.TempDir(), "checkpoint.json")
+	cp, err := LoadCheckpoint(cpf, dsn, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	since := time.Date(2022, 2, 3, 14, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		modify  func(o *Option)
+		wantErr bool
+	}{
+		{"same", func(o *Option) {}, false},
+		{"accounts in other order", func(o *Option) { o.Accounts = []string{"210987654321", "123456789012"} }, false},
+		{"date", func(o *Option) { o.DatePath = "2022/02/04" }, true},
+		{"start date", func(o *Option) { o.StartDatePath = "2022/02/01" }, true},
+		{"since", func(o *Option) { o.Since = since }, true},
+		{"accounts", func(o *Option) { o.Accounts = []string{"123456789012"} }, true},
+		{"regions", func(o *Option) { o.Regions = []string{"ap-northeast-1"} }, true},
+		{"organization", func(o *Option) { o.Organization = "o-abcdefghij" }, true},
+		{"filter", func(o *Option) { o.Filter = "" }, true},
+	}
+	for _, tt := range tests {
+		o := opt
+		tt.modify(&o)
+		_, err := LoadCheckpoint(cpf, dsn, o)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// the date paths are ignored and the current time is used for the zero until with since
+	cpf = filepath.Join(t.TempDir(), "checkpoint.json")
+	opt = Option{DatePath: "2022/02/03", Since: since}
+	cp, err = LoadCheckpoint(cpf, dsn, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.Save(); err != nil {
+		t.Fatal(err)
+	}
+	opt.DatePath = "2022/02/04"
+	if _, err := LoadCheckpoint(cpf, dsn, opt); err != nil {
+		t.Error(err)
+	}
+	opt.Until = since.Add(time.Hour)
+	if _, err := LoadCheckpoint(cpf, dsn, opt); err == nil {
+		t.Error("want error")
+	}
+}
+
+func TestCheckpointSaveFlush(t *testing.T) {
+	cpf := filepath.Join(t.TempDir(), "checkpoint.json")
+	cp, err := LoadCheckpoint(cpf, "file:///trail", Option{DatePath: "2022/02/03"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errFlush := errors.New("flush")
+	cp.SetFlushFunc(func() error { return errFlush })
+	cp.done("2022/02/03")
+	if err := cp.Save(); !errors.Is(err, errFlush) {
+		t.Errorf("got %v want %v", err, errFlush)
+	}
+	// the progress is not saved if the events can not be flushed
+	if _, err := os.Stat(cpf); !os.IsNotExist(err) {
+		t.Errorf("got %v want not exist", err)
+	}
+
+	flushed := 0
+	cp.SetFlushFunc(func() error { flushed += 1; return nil })
+	if err := cp.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if flushed != 1 {
+		t.Errorf("got %v want %v", flushed, 1)
+	}
+}
@@ -0,0 +1,299 @@
+package trail
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/goccy/go-json"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	sqsMaxMessages     = 10
+	sqsWaitTimeSeconds = 20
+)
+
+// QueueURL returns the URL of the SQS queue for dsn (eg. sqs://sqs.ap-northeast-1.amazonaws.com/1234567890/queue-name).
+// If endpoint is not empty (eg. http://localhost:9324 for a local SQS-compatible queue), it is used instead of https://<host>.
+func QueueURL(dsn, endpoint string) (string, error) {
+	if !strings.HasPrefix(dsn, "sqs://") {
+		return "", fmt.Errorf("invalid sqs queue url: %s", dsn)
+	}
+	splitted := strings.SplitN(strings.TrimPrefix(dsn, "sqs://"), "/", 2)
+	if len(splitted) != 2 || splitted[0] == "" || strings.Trim(splitted[1], "/") == "" {
+		return "", fmt.Errorf("invalid sqs queue url: %s", dsn)
+	}
+	if endpoint != "" {
+		return fmt.Sprintf("%s/%s", strings.TrimSuffix(endpoint, "/"), strings.Trim(splitted[1], "/")), nil
+	}
+	return fmt.Sprintf("https://%s/%s", splitted[0], strings.Trim(splitted[1], "/")), nil
+}
+
+// ConsumeEvents receives the notifications of trail log files delivered from the SQS queue and calls fn for the events in them.
+// The notifications of AWS CloudTrail (via Amazon SNS) and the event notifications of Amazon S3 are supported.
+// The events of each message are walked in order of timeline, and the message is deleted after all of them are walked.
+// If s3Endpoint is not empty (eg. http://localhost:9000 for a local S3-compatible storage), trail log files are got from it with path-style addressing.
+// It returns nil when ctx is canceled.
+func ConsumeEvents(ctx context.Context, sess *session.Session, queueURL, s3Endpoint string, opt Option, fn WalkEventsFunc) error {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return err
+	}
+	cfg := aws.NewConfig()
+	if !strings.HasSuffix(u.Hostname(), ".amazonaws.com") {
+		// local SQS-compatible queue
+		cfg = cfg.WithEndpoint(fmt.Sprintf("%s://%s", u.Scheme, u.Host))
+	}
+	s3cfg := aws.NewConfig()
+	if s3Endpoint != "" {
+		s3cfg = s3cfg.WithEndpoint(s3Endpoint).WithS3ForcePathStyle(true)
+	}
+	s3c := s3.New(sess, s3cfg)
+	c := &consumer{
+		sqsc:     sqs.New(sess, cfg),
+		queueURL: queueURL,
+		storages: map[string]Storage{},
+		newStorage: func(bucket string) (Storage, error) {
+			return &s3Storage{s3c: s3c, bucket: bucket}, nil
+		},
+		opt: opt,
+		fn:  fn,
+	}
+	return c.consume(ctx)
+}
+
+type consumer struct {
+	sqsc       sqsiface.SQSAPI
+	queueURL   string
+	storages   map[string]Storage
+	newStorage func(bucket string) (Storage, error)
+	opt        Option
+	filter     *Filter
+	fn         WalkEventsFunc
+}
+
+type s3Location struct {
+	bucket string
+	key    string
+}
+
+// cloudTrailNotification is the notification of AWS CloudTrail for trail log files delivered
+type cloudTrailNotification struct {
+	S3Bucket    string   `json:"s3Bucket"`
+	S3ObjectKey []string `json:"s3ObjectKey"`
+}
+
+// s3EventNotification is the event notification of Amazon S3
+type s3EventNotification struct {
+	Records []struct {
+		EventSource string `json:"eventSource"`
+		EventName   string `json:"eventName"`
+		S3          struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// snsNotification is the message of Amazon SNS delivered to SQS without raw message delivery
+type snsNotification struct {
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+}
+
+func (c *consumer) consume(ctx context.Context) error {
+	if c.opt.Filter != "" {
+		f, err := CompileFilter(c.opt.Filter)
+		if err != nil {
+			return err
+		}
+		c.filter = f
+	}
+	defer func() {
+		for _, s := range c.storages {
+			_ = s.Close()
+		}
+	}()
+	for {
+		o, err := c.sqsc.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(c.queueURL),
+			MaxNumberOfMessages: aws.Int64(sqsMaxMessages),
+			WaitTimeSeconds:     aws.Int64(sqsWaitTimeSeconds),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		for _, m := range o.Messages {
+			if err := c.process(ctx, m); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+}
+
+// process walks the events of the trail log files in the message and deletes it.
+// The message which is not a notification is deleted without walking, since it never can be processed.
+// If the trail log files can not be processed, the message is left to be received again (the redrive policy of the queue is required to stop it).
+func (c *consumer) process(ctx context.Context, m *sqs.Message) error {
+	locs, err := parseNotification(aws.StringValue(m.Body))
+	if err != nil {
+		log.Warn().Err(err).Str("message_id", aws.StringValue(m.MessageId)).Msg("Delete invalid message")
+		return c.delete(ctx, m)
+	}
+	records := []*Record{}
+	for _, l := range locs {
+		if !c.target(l.key) {
+			continue
+		}
+		rs, err := c.records(ctx, l)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			log.Error().Err(err).Str("message_id", aws.StringValue(m.MessageId)).Str("bucket", l.bucket).Str("key", l.key).Msg("Failed to get trail log")
+			return nil
+		}
+		records = append(records, rs...)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return eventKey(records[i]) < eventKey(records[j])
+	})
+	for _, r := range records {
+		if err := c.fn(r); err != nil {
+			return err
+		}
+	}
+	return c.delete(ctx, m)
+}
+
+func (c *consumer) delete(ctx context.Context, m *sqs.Message) error {
+	_, err := c.sqsc.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(c.queueURL),
+		ReceiptHandle: m.ReceiptHandle,
+	})
+	return err
+}
+
+// target reports whether the key is the trail log file of the target accounts and regions
+func (c *consumer) target(key string) bool {
+	k, err := ParseObjectKey(key)
+	if err != nil {
+		// eg. digest files
+		return false
+	}
+	if c.opt.Organization != "" && k.Organization != c.opt.Organization {
+		return false
+	}
+	if len(c.opt.Accounts) > 0 && !contains(c.opt.Accounts, k.AccountID) {
+		return false
+	}
+	if len(c.opt.Regions) > 0 && !contains(c.opt.Regions, k.Region) {
+		return false
+	}
+	return true
+}
+
+// records returns the records of the trail log file matched with the filter
+func (c *consumer) records(ctx context.Context, l s3Location) ([]*Record, error) {
+	s, ok := c.storages[l.bucket]
+	if !ok {
+		var err error
+		s, err = c.newStorage(l.bucket)
+		if err != nil {
+			return nil, err
+		}
+		c.storages[l.bucket] = s
+	}
+	log.Info().Str("bucket", l.bucket).Str("key", l.key).Msg("Digging trail log")
+	var td *LogData
+	if err := retry(ctx, maxRetries(c.opt), func() error {
+		var err error
+		td, err = getLogData(ctx, s, l.key)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	if c.filter == nil {
+		return td.Records, nil
+	}
+	records := []*Record{}
+	for _, r := range td.Records {
+		m, err := c.filter.Match(r)
+		if err != nil {
+			return nil, err
+		}
+		if m {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+// parseNotification returns the locations of the objects in the notification
+func parseNotification(body string) ([]s3Location, error) {
+	sn := &snsNotification{}
+	if err := json.Unmarshal([]byte(body), sn); err != nil {
+		return nil, fmt.Errorf("invalid notification: %w", err)
+	}
+	if sn.Type == "Notification" {
+		body = sn.Message
+	}
+
+	cn := &cloudTrailNotification{}
+	if err := json.Unmarshal([]byte(body), cn); err != nil {
+		return nil, fmt.Errorf("invalid notification: %w", err)
+	}
+	if cn.S3Bucket != "" {
+		locs := []s3Location{}
+		for _, k := range cn.S3ObjectKey {
+			locs = append(locs, s3Location{bucket: cn.S3Bucket, key: k})
+		}
+		return locs, nil
+	}
+
+	en := &s3EventNotification{}
+	if err := json.Unmarshal([]byte(body), en); err != nil {
+		return nil, fmt.Errorf("invalid notification: %w", err)
+	}
+	locs := []s3Location{}
+	for _, r := range en.Records {
+		if r.EventSource != "aws:s3" || !strings.HasPrefix(r.EventName, "ObjectCreated:") {
+			continue
+		}
+		// The object key of S3 event notifications is URL-encoded
+		k, err := url.QueryUnescape(r.S3.Object.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid object key: %s", r.S3.Object.Key)
+		}
+		locs = append(locs, s3Location{bucket: r.S3.Bucket.Name, key: k})
+	}
+	// eg. s3:TestEvent has no records
+	return locs, nil
+}
+
+func contains(s []string, e string) bool {
+	for _, v := range s {
+		if v == e {
+			return true
+		}
+	}
+	return false
+}
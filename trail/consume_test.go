@@ -0,0 +1,154 @@
+package trail
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/goccy/go-json"
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeSQS struct {
+	sqsiface.SQSAPI
+	messages []*sqs.Message
+	deleted  []string
+	cancel   context.CancelFunc
+}
+
+func (f *fakeSQS) ReceiveMessageWithContext(ctx context.Context, i *sqs.ReceiveMessageInput, _ ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	if len(f.messages) == 0 {
+		f.cancel()
+		return nil, ctx.Err()
+	}
+	n := int(aws.Int64Value(i.MaxNumberOfMessages))
+	if n > len(f.messages) {
+		n = len(f.messages)
+	}
+	o := &sqs.ReceiveMessageOutput{Messages: f.messages[:n]}
+	f.messages = f.messages[n:]
+	return o, nil
+}
+
+func (f *fakeSQS) DeleteMessageWithContext(ctx context.Context, i *sqs.DeleteMessageInput, _ ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	f.deleted = append(f.deleted, aws.StringValue(i.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func TestConsumeEvents(t *testing.T) {
+	dir := t.TempDir()
+	d := time.Date(2022, 2, 3, 0, 0, 0, 0, time.UTC)
+	k1 := writeTrailLog(t, dir, "123456789012", "ap-northeast-1", d.Add(5*time.Minute), testRecord("b", d.Add(2*time.Minute)), testRecord("a", d.Add(1*time.Minute)))
+	k2 := writeTrailLog(t, dir, "o-abcdefghij/210987654321", "us-east-1", d.Add(10*time.Minute), testRecord("c", d.Add(3*time.Minute)))
+	k3 := writeTrailLog(t, dir, "123456789012", "us-west-2", d.Add(15*time.Minute), testRecord("d", d.Add(4*time.Minute)))
+
+	inner, err := json.Marshal(map[string]interface{}{"s3Bucket": "bucket", "s3ObjectKey": []string{k1, k2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sns, err := json.Marshal(map[string]string{"Type": "Notification", "Message": string(inner)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := fmt.Sprintf(`{"Records":[{"eventSource":"aws:s3","eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"bucket"},"object":{"key":"%s"}}}]}`, url.QueryEscape(k3))
+	missing := `{"s3Bucket":"bucket","s3ObjectKey":["AWSLogs/123456789012/CloudTrail/ap-northeast-1/2022/02/03/123456789012_CloudTrail_ap-northeast-1_20220203T0020Z_missing.json.gz"]}`
+	test := `{"Service":"Amazon S3","Event":"s3:TestEvent","Bucket":"bucket"}`
+
+	tests := []struct {
+		opt         Option
+		want        []string
+		wantDeleted []string
+	}{
+		{
+			Option{MaxRetries: -1},
+			[]string{"a", "b", "c", "d"},
+			[]string{"sns", "event", "test", "invalid"},
+		},
+		{
+			Option{MaxRetries: -1, Regions: []string{"ap-northeast-1", "us-east-1"}, Filter: `eventID != "b"`},
+			[]string{"a", "c"},
+			[]string{"sns", "event", "test", "invalid"},
+		},
+		{
+			Option{MaxRetries: -1, Organization: "o-abcdefghij"},
+			[]string{"c"},
+			// the missing trail log file is not the target
+			[]string{"sns", "event", "missing", "test", "invalid"},
+		},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		f := &fakeSQS{
+			messages: []*sqs.Message{
+				{MessageId: aws.String("1"), ReceiptHandle: aws.String("sns"), Body: aws.String(string(sns))},
+				{MessageId: aws.String("2"), ReceiptHandle: aws.String("event"), Body: aws.String(event)},
+				{MessageId: aws.String("3"), ReceiptHandle: aws.String("missing"), Body: aws.String(missing)},
+				{MessageId: aws.String("4"), ReceiptHandle: aws.String("test"), Body: aws.String(test)},
+				{MessageId: aws.String("5"), ReceiptHandle: aws.String("invalid"), Body: aws.String("invalid")},
+			},
+			cancel: cancel,
+		}
+		got := []string{}
+		c := &consumer{
+			sqsc:     f,
+			queueURL: "https://sqs.ap-northeast-1.amazonaws.com/123456789012/queue",
+			storages: map[string]Storage{},
+			newStorage: func(bucket string) (Storage, error) {
+				if bucket != "bucket" {
+					t.Errorf("got %v want %v", bucket, "bucket")
+				}
+				return &dirStorage{root: dir}, nil
+			},
+			opt: tt.opt,
+			fn: func(r *Record) error {
+				got = append(got, r.EventID)
+				return nil
+			},
+		}
+		if err := c.consume(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, tt.want, nil); diff != "" {
+			t.Errorf("%s", diff)
+		}
+		if diff := cmp.Diff(f.deleted, tt.wantDeleted, nil); diff != "" {
+			t.Errorf("%s", diff)
+		}
+	}
+}
+
+func TestQueueURL(t *testing.T) {
+	tests := []struct {
+		dsn      string
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{"sqs://sqs.ap-northeast-1.amazonaws.com/123456789012/queue", "", "https://sqs.ap-northeast-1.amazonaws.com/123456789012/queue", false},
+		{"sqs://localhost/000000000000/queue", "http://localhost:9324/", "http://localhost:9324/000000000000/queue", false},
+		{"sqs://sqs.ap-northeast-1.amazonaws.com", "", "", true},
+		{"s3://bucket/AWSLogs", "", "", true},
+	}
+	for _, tt := range tests {
+		got, err := QueueURL(tt.dsn, tt.endpoint)
+		if err != nil {
+			if !tt.wantErr {
+				t.Error(err)
+			}
+			continue
+		}
+		if tt.wantErr {
+			t.Errorf("%s: want error", tt.dsn)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("got %v want %v", got, tt.want)
+		}
+	}
+}
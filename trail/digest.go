@@ -0,0 +1,441 @@
+package trail
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/goccy/go-json"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+const (
+	ValidationStatusValid            = "valid"
+	ValidationStatusMissing          = "missing"
+	ValidationStatusModified         = "modified"
+	ValidationStatusExtra            = "extra"
+	ValidationStatusInvalidDigest    = "invalid-digest"
+	ValidationStatusUnverifiedDigest = "unverified-digest"
+)
+
+const digestSignatureAlgorithm = "SHA256withRSA"
+
+var digestKeyRe = regexp.MustCompile(`/CloudTrail-Digest/[a-z0-9\-]+/([0-9]{4}/[0-9]{2}/[0-9]{2})/[^/]+$`)
+
+// Digest is the digest file of AWS CloudTrail log file integrity validation
+// https://docs.aws.amazon.com/awscloudtrail/latest/userguide/cloudtrail-log-file-validation-digest-file-structure.html
+type Digest struct {
+	AWSAccountID                string  `json:"awsAccountId"`
+	DigestStartTime             string  `json:"digestStartTime"`
+	DigestEndTime               string  `json:"digestEndTime"`
+	DigestS3Bucket              string  `json:"digestS3Bucket"`
+	DigestS3Object              string  `json:"digestS3Object"`
+	DigestPublicKeyFingerprint  string  `json:"digestPublicKeyFingerprint"`
+	DigestSignatureAlgorithm    string  `json:"digestSignatureAlgorithm"`
+	NewestEventTime             string  `json:"newestEventTime"`
+	OldestEventTime             string  `json:"oldestEventTime"`
+	PreviousDigestS3Bucket      *string `json:"previousDigestS3Bucket"`
+	PreviousDigestS3Object      *string `json:"previousDigestS3Object"`
+	PreviousDigestHashValue     *string `json:"previousDigestHashValue"`
+	PreviousDigestHashAlgorithm *string `json:"previousDigestHashAlgorithm"`
+	PreviousDigestSignature     *string `json:"previousDigestSignature"`
+	LogFiles                    []struct {
+		S3Bucket        string `json:"s3Bucket"`
+		S3Object        string `json:"s3Object"`
+		HashValue       string `json:"hashValue"`
+		HashAlgorithm   string `json:"hashAlgorithm"`
+		NewestEventTime string `json:"newestEventTime"`
+		OldestEventTime string `json:"oldestEventTime"`
+	} `json:"logFiles"`
+}
+
+// ValidationResult is the result of the validation of a trail log file or a digest file
+type ValidationResult struct {
+	AccountID string
+	Region    string
+	Day       time.Time
+	Key       string
+	Status    string
+	Message   string
+}
+
+// ValidateFunc is called for each result. It may be called concurrently.
+type ValidateFunc func(r *ValidationResult) error
+
+type digestFile struct {
+	key       string
+	day       time.Time
+	hash      string
+	digest    *Digest
+	signature string
+	err       error
+}
+
+// LoadPublicKeys loads the public keys of AWS CloudTrail (PEM) to verify the signatures of digest files
+func LoadPublicKeys(files []string) ([]*rsa.PublicKey, error) {
+	keys := []*rsa.PublicKey{}
+	for _, f := range files {
+		b, err := os.ReadFile(filepath.Clean(f))
+		if err != nil {
+			return nil, err
+		}
+		for {
+			var block *pem.Block
+			block, b = pem.Decode(b)
+			if block == nil {
+				break
+			}
+			switch block.Type {
+			case "RSA PUBLIC KEY":
+				k, err := x509.ParsePKCS1PublicKey(block.Bytes)
+				if err != nil {
+					return nil, fmt.Errorf("invalid public key %s: %w", f, err)
+				}
+				keys = append(keys, k)
+			case "PUBLIC KEY":
+				k, err := x509.ParsePKIXPublicKey(block.Bytes)
+				if err != nil {
+					return nil, fmt.Errorf("invalid public key %s: %w", f, err)
+				}
+				rk, ok := k.(*rsa.PublicKey)
+				if !ok {
+					return nil, fmt.Errorf("invalid public key %s: not RSA public key", f)
+				}
+				keys = append(keys, rk)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// ValidateLogFiles validates trail log files using the digest files next to them.
+// If keys is empty, the signatures of the digest files are not verified. Otherwise, digest files without the signature are invalid.
+// For local trail logs, the signature is read from the file next to the digest file (eg. xxx.json.gz.signature).
+func ValidateLogFiles(sess *session.Session, dsn string, opt Option, keys []*rsa.PublicKey, fn ValidateFunc) error {
+	return ValidateLogFilesWithContext(context.Background(), sess, dsn, opt, keys, fn)
+}
+
+// ValidateLogFilesWithContext validates trail log files using the digest files next to them.
+// When ctx is canceled, it stops validating and returns the error of ctx.
+func ValidateLogFilesWithContext(ctx context.Context, sess *session.Session, dsn string, opt Option, keys []*rsa.PublicKey, fn ValidateFunc) error {
+	s, prefix, err := NewStorage(sess, dsn)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = s.Close()
+	}()
+	// The digest file of the last hour of the day is delivered the following day
+	prefixes, err := generatePrefixes(ctx, sess, s, prefix, opt, true)
+	if err != nil {
+		return err
+	}
+	roots := []string{}
+	days := map[string][]time.Time{}
+	for _, pd := range prefixes {
+		for _, p := range pd.prefixes {
+			root := strings.TrimSuffix(p, fmt.Sprintf("/%s/", pd.day.Format(datePathFormat)))
+			if _, ok := days[root]; !ok {
+				roots = append(roots, root)
+			}
+			days[root] = append(days[root], pd.day)
+		}
+	}
+	sem := semaphore.NewWeighted(concurrency(opt))
+	retries := maxRetries(opt)
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, root := range roots {
+		func(root string) {
+			eg.Go(func() error {
+				if err := sem.Acquire(ctx, 1); err != nil {
+					return err
+				}
+				defer sem.Release(1)
+				log.Info().Str("prefix", root).Msg("Validating trail logs")
+				d := days[root]
+				return validateRoot(ctx, s, prefix, root, d[:len(d)-1], d[len(d)-1], keys, retries, fn)
+			})
+		}(root)
+	}
+	return eg.Wait()
+}
+
+func validateRoot(ctx context.Context, s Storage, prefix, root string, days []time.Time, after1Day time.Time, keys []*rsa.PublicKey, retries int, fn ValidateFunc) error {
+	region := path.Base(root)
+	account := path.Base(path.Dir(path.Dir(root)))
+	inRange := map[string]bool{}
+	for _, d := range days {
+		inRange[d.Format(datePathFormat)] = true
+	}
+	result := func(key string, day time.Time, status, msg string) error {
+		return fn(&ValidationResult{
+			AccountID: account,
+			Region:    region,
+			Day:       day,
+			Key:       key,
+			Status:    status,
+			Message:   msg,
+		})
+	}
+
+	// Digest files
+	digestRoot := strings.Replace(root, "/CloudTrail/", "/CloudTrail-Digest/", 1)
+	digests := map[string]*digestFile{}
+	dkeys := []string{}
+	for _, d := range append(days, after1Day) {
+		if err := s.ListObjects(ctx, fmt.Sprintf("%s/", path.Join(digestRoot, d.Format(datePathFormat))), "", func(o *s3.Object) error {
+			var df *digestFile
+			if err := retry(ctx, retries, func() error {
+				var err error
+				df, err = loadDigestFile(ctx, s, *o.Key)
+				return err
+			}); err != nil {
+				return err
+			}
+			digests[df.key] = df
+			dkeys = append(dkeys, df.key)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	sort.Strings(dkeys)
+
+	// Log files listed in digest files
+	expected := map[string]string{}
+	for _, k := range dkeys {
+		df := digests[k]
+		if df.err != nil {
+			continue
+		}
+		for _, l := range df.digest.LogFiles {
+			key := storageKey(prefix, l.S3Object)
+			if !strings.HasPrefix(key, fmt.Sprintf("%s/", root)) {
+				continue
+			}
+			ok, err := ParseObjectKey(key)
+			if err != nil || !inRange[ok.Day.Format(datePathFormat)] {
+				continue
+			}
+			expected[key] = l.HashValue
+		}
+	}
+
+	// Log files in storage
+	listed := map[string]bool{}
+	lkeys := []string{}
+	for _, d := range days {
+		if err := s.ListObjects(ctx, fmt.Sprintf("%s/", path.Join(root, d.Format(datePathFormat))), "", func(o *s3.Object) error {
+			listed[*o.Key] = true
+			lkeys = append(lkeys, *o.Key)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	ekeys := []string{}
+	for k := range expected {
+		ekeys = append(ekeys, k)
+	}
+	sort.Strings(ekeys)
+	for _, k := range ekeys {
+		ok, err := ParseObjectKey(k)
+		if err != nil {
+			return err
+		}
+		if !listed[k] {
+			if err := result(k, ok.Day, ValidationStatusMissing, "log file listed in the digest file not found"); err != nil {
+				return err
+			}
+			continue
+		}
+		var h string
+		if err := retry(ctx, retries, func() error {
+			var err error
+			h, err = hashObject(ctx, s, k)
+			return err
+		}); err != nil {
+			return err
+		}
+		if h != expected[k] {
+			if err := result(k, ok.Day, ValidationStatusModified, fmt.Sprintf("hash value does not match (expected: %s, actual: %s)", expected[k], h)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := result(k, ok.Day, ValidationStatusValid, ""); err != nil {
+			return err
+		}
+	}
+	for _, k := range lkeys {
+		if _, ok := expected[k]; ok {
+			continue
+		}
+		ok, err := ParseObjectKey(k)
+		if err != nil {
+			return err
+		}
+		if err := result(k, ok.Day, ValidationStatusExtra, "log file not listed in any digest file"); err != nil {
+			return err
+		}
+	}
+
+	// Digest chain and signatures
+	for _, k := range dkeys {
+		df := digests[k]
+		day := df.day
+		if df.err != nil {
+			if !inRange[day.Format(datePathFormat)] {
+				continue
+			}
+			if err := result(k, day, ValidationStatusInvalidDigest, df.err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if df.digest.PreviousDigestS3Object != nil && *df.digest.PreviousDigestS3Object != "" {
+			pk := storageKey(prefix, *df.digest.PreviousDigestS3Object)
+			prev, ok := digests[pk]
+			if !ok {
+				p, err := loadDigestFile(ctx, s, pk)
+				if err == nil {
+					prev = p
+				}
+			}
+			switch {
+			case prev == nil:
+				pday, err := parseDigestDay(pk)
+				if err == nil && inRange[pday.Format(datePathFormat)] {
+					if err := result(pk, pday, ValidationStatusMissing, fmt.Sprintf("previous digest file of %s not found", k)); err != nil {
+						return err
+					}
+				}
+			case df.digest.PreviousDigestHashValue == nil || prev.hash != *df.digest.PreviousDigestHashValue:
+				if inRange[prev.day.Format(datePathFormat)] || inRange[day.Format(datePathFormat)] {
+					if err := result(pk, prev.day, ValidationStatusInvalidDigest, fmt.Sprintf("hash value does not match the value in the next digest file %s", k)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if !inRange[day.Format(datePathFormat)] {
+			continue
+		}
+		if len(keys) == 0 {
+			if err := result(k, day, ValidationStatusUnverifiedDigest, "signature of the digest file is not verified"); err != nil {
+				return err
+			}
+			continue
+		}
+		// A digest file without the signature can not be trusted because the signature can be stripped by anyone who can modify it
+		if df.signature == "" {
+			if err := result(k, day, ValidationStatusInvalidDigest, "signature of the digest file not found"); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := verifyDigestSignature(df, keys); err != nil {
+			if err := result(k, day, ValidationStatusInvalidDigest, err.Error()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadDigestFile loads the digest file. Errors of the content of the digest file are kept in digestFile.err
+func loadDigestFile(ctx context.Context, s Storage, key string) (*digestFile, error) {
+	day, err := parseDigestDay(key)
+	if err != nil {
+		return nil, err
+	}
+	df := &digestFile{key: key, day: day}
+	b, err := readObject(ctx, s, key)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	df.hash = hex.EncodeToString(sum[:])
+	d := &Digest{}
+	if err := json.Unmarshal(b, d); err != nil {
+		df.err = fmt.Errorf("invalid digest file: %w", err)
+		return df, nil
+	}
+	df.digest = d
+	m, err := s.GetObjectMetadata(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	df.signature = m["signature"]
+	return df, nil
+}
+
+func verifyDigestSignature(df *digestFile, keys []*rsa.PublicKey) error {
+	if df.digest.DigestSignatureAlgorithm != digestSignatureAlgorithm {
+		return fmt.Errorf("unsupported signature algorithm: %s", df.digest.DigestSignatureAlgorithm)
+	}
+	sig, err := hex.DecodeString(df.signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	prevSig := "null"
+	if df.digest.PreviousDigestSignature != nil {
+		prevSig = *df.digest.PreviousDigestSignature
+	}
+	// https://docs.aws.amazon.com/awscloudtrail/latest/userguide/cloudtrail-log-file-custom-validation.html
+	signing := fmt.Sprintf("%s\n%s/%s\n%s\n%s", df.digest.DigestEndTime, df.digest.DigestS3Bucket, df.digest.DigestS3Object, df.hash, prevSig)
+	h := sha256.Sum256([]byte(signing))
+	for _, k := range keys {
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature of the digest file is invalid (public key fingerprint: %s)", df.digest.DigestPublicKeyFingerprint)
+}
+
+func parseDigestDay(key string) (time.Time, error) {
+	matches := digestKeyRe.FindStringSubmatch(key)
+	if matches == nil {
+		return time.Time{}, fmt.Errorf("invalid digest file key: %s", key)
+	}
+	return time.Parse(datePathFormat, matches[1])
+}
+
+// storageKey converts the S3 object key in the digest file to the key in the storage
+func storageKey(prefix, key string) string {
+	if strings.HasPrefix(key, fmt.Sprintf("%s/", prefix)) {
+		return key
+	}
+	base := path.Base(prefix)
+	i := strings.Index(fmt.Sprintf("/%s", key), fmt.Sprintf("/%s/", base))
+	if i < 0 {
+		return key
+	}
+	return path.Join(path.Dir(prefix), key[i:])
+}
+
+func hashObject(ctx context.Context, s Storage, key string) (string, error) {
+	b, err := readObject(ctx, s, key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
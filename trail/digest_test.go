@@ -0,0 +1,234 @@
+package trail
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeDigest(t *testing.T, root, account, region string, end time.Time, prev *digestFile, logKeys ...string) *digestFile {
+	t.Helper()
+	key := fmt.Sprintf("AWSLogs/%s/CloudTrail-Digest/%s/%s/%s_CloudTrail-Digest_%s_trail_%s_%s.json.gz", account, region, end.Format(datePathFormat), account, region, region, end.Format("20060102T150405Z"))
+	d := map[string]interface{}{
+		"awsAccountId":             account,
+		"digestStartTime":          end.Add(-time.Hour).Format(time.RFC3339),
+		"digestEndTime":            end.Format(time.RFC3339),
+		"digestS3Bucket":           "bucket",
+		"digestS3Object":           key,
+		"digestSignatureAlgorithm": digestSignatureAlgorithm,
+		"logFiles":                 []interface{}{},
+	}
+	if prev != nil {
+		d["previousDigestS3Bucket"] = "bucket"
+		d["previousDigestS3Object"] = prev.key
+		d["previousDigestHashValue"] = prev.hash
+		d["previousDigestHashAlgorithm"] = "SHA-256"
+	}
+	files := []interface{}{}
+	for _, k := range logKeys {
+		h, err := hashObject(context.Background(), &dirStorage{root: root}, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, map[string]string{
+			"s3Bucket":      "bucket",
+			"s3Object":      k,
+			"hashValue":     h,
+			"hashAlgorithm": "SHA-256",
+		})
+	}
+	d["logFiles"] = files
+	b, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := gzip.NewWriter(f)
+	if _, err := gw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(b)
+	return &digestFile{key: key, hash: hex.EncodeToString(sum[:])}
+}
+
+func TestValidateLogFiles(t *testing.T) {
+	dir := t.TempDir()
+	a := "123456789012"
+	r := "ap-northeast-1"
+	d := time.Date(2022, 2, 3, 0, 0, 0, 0, time.UTC)
+	l1 := writeTrailLog(t, dir, a, r, d.Add(1*time.Hour+5*time.Minute), testRecord("a", d.Add(1*time.Hour)))
+	l2 := writeTrailLog(t, dir, a, r, d.Add(1*time.Hour+10*time.Minute), testRecord("b", d.Add(1*time.Hour)))
+	l3 := writeTrailLog(t, dir, a, r, d.Add(23*time.Hour+30*time.Minute), testRecord("c", d.Add(23*time.Hour)))
+	l4 := writeTrailLog(t, dir, a, r, d.Add(12*time.Hour), testRecord("d", d.Add(12*time.Hour)))
+	d1 := writeDigest(t, dir, a, r, d.Add(2*time.Hour), nil, l1, l2)
+	d2 := writeDigest(t, dir, a, r, d.Add(13*time.Hour), d1, l4)
+	dx := writeDigest(t, dir, a, r, d.Add(14*time.Hour), d2)
+	d3 := writeDigest(t, dir, a, r, d.Add(24*time.Hour+30*time.Second), dx, l3)
+	_ = writeDigest(t, dir, a, r, d.Add(25*time.Hour), d3)
+
+	// modified
+	writeTrailLog(t, dir, a, r, d.Add(1*time.Hour+10*time.Minute), testRecord("tampered", d.Add(1*time.Hour)))
+	// missing
+	if err := os.Remove(filepath.Join(dir, filepath.FromSlash(l4))); err != nil {
+		t.Fatal(err)
+	}
+	// extra
+	l5 := writeTrailLog(t, dir, a, r, d.Add(15*time.Hour), testRecord("e", d.Add(15*time.Hour)))
+	// missing digest
+	if err := os.Remove(filepath.Join(dir, filepath.FromSlash(dx.key))); err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	if err := ValidateLogFiles(nil, "file://"+dir, Option{DatePath: "2022/02/03"}, nil, func(v *ValidationResult) error {
+		if v.AccountID != a || v.Region != r || v.Day != d {
+			t.Errorf("invalid result: %v", v)
+		}
+		got = append(got, fmt.Sprintf("%s %s", v.Status, v.Key))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	want := []string{
+		fmt.Sprintf("%s %s", ValidationStatusExtra, l5),
+		fmt.Sprintf("%s %s", ValidationStatusMissing, dx.key),
+		fmt.Sprintf("%s %s", ValidationStatusMissing, l4),
+		fmt.Sprintf("%s %s", ValidationStatusModified, l2),
+		fmt.Sprintf("%s %s", ValidationStatusUnverifiedDigest, d1.key),
+		fmt.Sprintf("%s %s", ValidationStatusUnverifiedDigest, d2.key),
+		fmt.Sprintf("%s %s", ValidationStatusValid, l1),
+		fmt.Sprintf("%s %s", ValidationStatusValid, l3),
+	}
+	if diff := cmp.Diff(got, want, nil); diff != "" {
+		t.Errorf("%s", diff)
+	}
+}
+
+func TestValidateLogFilesSignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemFile := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadPublicKeys([]string{pemFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	a := "123456789012"
+	r := "ap-northeast-1"
+	d := time.Date(2022, 2, 3, 0, 0, 0, 0, time.UTC)
+	l1 := writeTrailLog(t, dir, a, r, d.Add(1*time.Hour+5*time.Minute), testRecord("a", d.Add(1*time.Hour)))
+	d1 := writeDigest(t, dir, a, r, d.Add(2*time.Hour), nil, l1)
+	d2 := writeDigest(t, dir, a, r, d.Add(3*time.Hour), d1)
+	// only d1 is signed, and the signature of d2 is stripped
+	signing := fmt.Sprintf("%s\nbucket/%s\n%s\nnull", d.Add(2*time.Hour).Format(time.RFC3339), d1.key, d1.hash)
+	h := sha256.Sum256([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(d1.key))+signatureFileSuffix, []byte(hex.EncodeToString(sig)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	if err := ValidateLogFiles(nil, "file://"+dir, Option{DatePath: "2022/02/03"}, keys, func(v *ValidationResult) error {
+		got = append(got, fmt.Sprintf("%s %s", v.Status, v.Key))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	want := []string{
+		fmt.Sprintf("%s %s", ValidationStatusInvalidDigest, d2.key),
+		fmt.Sprintf("%s %s", ValidationStatusValid, l1),
+	}
+	if diff := cmp.Diff(got, want, nil); diff != "" {
+		t.Errorf("%s", diff)
+	}
+}
+
+func TestVerifyDigestSignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemFile := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadPublicKeys([]string{pemFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevSig := "abcdef"
+	df := &digestFile{
+		hash: "0123456789abcdef",
+		digest: &Digest{
+			DigestEndTime:            "2022-02-03T02:00:00Z",
+			DigestS3Bucket:           "bucket",
+			DigestS3Object:           "AWSLogs/123456789012/CloudTrail-Digest/ap-northeast-1/2022/02/03/xxx.json.gz",
+			DigestSignatureAlgorithm: digestSignatureAlgorithm,
+			PreviousDigestSignature:  &prevSig,
+		},
+	}
+	sign := func(k *rsa.PrivateKey) string {
+		h := sha256.Sum256([]byte("2022-02-03T02:00:00Z\nbucket/AWSLogs/123456789012/CloudTrail-Digest/ap-northeast-1/2022/02/03/xxx.json.gz\n0123456789abcdef\nabcdef"))
+		s, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, h[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hex.EncodeToString(s)
+	}
+
+	df.signature = sign(key)
+	if err := verifyDigestSignature(df, keys); err != nil {
+		t.Error(err)
+	}
+	df.signature = sign(other)
+	if err := verifyDigestSignature(df, keys); err == nil {
+		t.Error("want error")
+	}
+	df.signature = sign(key)
+	df.hash = "fedcba9876543210"
+	if err := verifyDigestSignature(df, keys); err == nil {
+		t.Error("want error")
+	}
+}
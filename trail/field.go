@@ -0,0 +1,77 @@
+package trail
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// Fields returns the record as a generic JSON object
+func (r *Record) Fields() (map[string]interface{}, error) {
+	b, err := r.MarshalRaw()
+	if err != nil {
+		return nil, err
+	}
+	f := map[string]interface{}{}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Value returns the value of the field specified by the dotted path (eg. userIdentity.arn)
+func (r *Record) Value(path string) (interface{}, bool) {
+	f, err := r.Fields()
+	if err != nil {
+		return nil, false
+	}
+	return Lookup(f, path)
+}
+
+// Lookup returns the value of the field specified by the dotted path.
+// Elements of arrays are specified by index (eg. resources.0.ARN).
+func Lookup(fields map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = fields
+	for _, k := range strings.Split(path, ".") {
+		switch vv := v.(type) {
+		case map[string]interface{}:
+			c, ok := vv[k]
+			if !ok {
+				return nil, false
+			}
+			v = c
+		case []interface{}:
+			i, err := strconv.Atoi(k)
+			if err != nil || i < 0 || len(vv) <= i {
+				return nil, false
+			}
+			v = vv[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// ValueString returns the string representation of the value returned by Lookup
+func ValueString(v interface{}) string {
+	switch vv := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return vv
+	case bool:
+		return strconv.FormatBool(vv)
+	case float64:
+		return strconv.FormatFloat(vv, 'f', -1, 64)
+	case json.Number:
+		return vv.String()
+	default:
+		b, err := json.Marshal(vv)
+		if err != nil {
+			return ""
+		}
+		return string(b)
+	}
+}
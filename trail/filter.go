@@ -0,0 +1,423 @@
+package trail
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Filter is a compiled filter expression for records.
+//
+//	eventSource == "iam.amazonaws.com" && errorCode != ""
+//	eventName =~ "^(Delete|Put)" || !readOnly
+//	sourceIPAddress in "192.0.2.0/24"
+//
+// Supported operators are ==, !=, =~, !~, <, <=, >, >=, in (CIDR), &&, ||, ! and parentheses.
+// Fields are specified by dotted paths (eg. userIdentity.arn). A missing field is treated as empty.
+type Filter struct {
+	expr string
+	root node
+}
+
+// CompileFilter parses the filter expression
+func CompileFilter(expr string) (*Filter, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %s: %w", expr, err)
+	}
+	p := &parser{tokens: tokens}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %s: %w", expr, err)
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("invalid filter: %s: unexpected %q", expr, t.text)
+	}
+	return &Filter{expr: expr, root: n}, nil
+}
+
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Match reports whether the record matches the filter
+func (f *Filter) Match(r *Record) (bool, error) {
+	fields, err := r.Fields()
+	if err != nil {
+		return false, err
+	}
+	return f.MatchFields(fields), nil
+}
+
+// MatchFields reports whether the fields returned by Record.Fields match the filter
+func (f *Filter) MatchFields(fields map[string]interface{}) bool {
+	return f.root.eval(fields)
+}
+
+type node interface {
+	eval(fields map[string]interface{}) bool
+}
+
+type orNode struct {
+	l, r node
+}
+
+func (n *orNode) eval(fields map[string]interface{}) bool {
+	return n.l.eval(fields) || n.r.eval(fields)
+}
+
+type andNode struct {
+	l, r node
+}
+
+func (n *andNode) eval(fields map[string]interface{}) bool {
+	return n.l.eval(fields) && n.r.eval(fields)
+}
+
+type notNode struct {
+	n node
+}
+
+func (n *notNode) eval(fields map[string]interface{}) bool {
+	return !n.n.eval(fields)
+}
+
+type truthNode struct {
+	o operand
+}
+
+func (n *truthNode) eval(fields map[string]interface{}) bool {
+	switch v := n.o.value(fields).(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	default:
+		return true
+	}
+}
+
+type cmpNode struct {
+	op      string
+	l, r    operand
+	re      *regexp.Regexp
+	cidr    *net.IPNet
+	numeric bool
+}
+
+func (n *cmpNode) eval(fields map[string]interface{}) bool {
+	lv := n.l.value(fields)
+	switch n.op {
+	case "=~":
+		return n.re.MatchString(ValueString(lv))
+	case "!~":
+		return !n.re.MatchString(ValueString(lv))
+	case "in":
+		ip := net.ParseIP(ValueString(lv))
+		return ip != nil && n.cidr.Contains(ip)
+	}
+	c := compare(lv, n.r.value(fields), n.numeric)
+	switch n.op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// compare compares values as numbers if numeric (compared with a number literal) or both are JSON numbers, otherwise as strings.
+// Numeric strings such as account IDs are compared as strings to keep leading zeros and precision.
+func compare(l, r interface{}, numeric bool) int {
+	ls := ValueString(l)
+	rs := ValueString(r)
+	_, lnum := l.(float64)
+	_, rnum := r.(float64)
+	if !numeric && !(lnum && rnum) {
+		return strings.Compare(ls, rs)
+	}
+	lf, lerr := strconv.ParseFloat(ls, 64)
+	rf, rerr := strconv.ParseFloat(rs, 64)
+	if lerr == nil && rerr == nil {
+		switch {
+		case lf < rf:
+			return -1
+		case lf > rf:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return strings.Compare(ls, rs)
+}
+
+type operand interface {
+	value(fields map[string]interface{}) interface{}
+}
+
+type fieldOperand struct {
+	path string
+}
+
+func (o *fieldOperand) value(fields map[string]interface{}) interface{} {
+	v, _ := Lookup(fields, o.path)
+	return v
+}
+
+type literalOperand struct {
+	v interface{}
+}
+
+func (o *literalOperand) value(_ map[string]interface{}) interface{} {
+	return o.v
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+var operators = []string{"&&", "||", "==", "!=", "=~", "!~", "<=", ">=", "<", ">", "!"}
+
+func tokenize(expr string) ([]token, error) {
+	tokens := []token{}
+	i := 0
+L:
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")"})
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for ; j < len(expr) && expr[j] != c; j++ {
+				if expr[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(expr) {
+				return nil, fmt.Errorf("unterminated string: %s", expr[i:])
+			}
+			s := expr[i+1 : j]
+			if c == '"' {
+				u, err := strconv.Unquote(expr[i : j+1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid string: %s", expr[i:j+1])
+				}
+				s = u
+			}
+			tokens = append(tokens, token{tokString, s})
+			i = j + 1
+		case c == '-' || ('0' <= c && c <= '9'):
+			j := i + 1
+			for j < len(expr) && (('0' <= expr[j] && expr[j] <= '9') || expr[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, expr[i:j]})
+			i = j
+		case isIdentChar(c):
+			j := i
+			for j < len(expr) && (isIdentChar(expr[j]) || expr[j] == '.' || expr[j] == '-') {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, expr[i:j]})
+			i = j
+		default:
+			for _, op := range operators {
+				if strings.HasPrefix(expr[i:], op) {
+					tokens = append(tokens, token{tokOp, op})
+					i += len(op)
+					continue L
+				}
+			}
+			return nil, fmt.Errorf("unexpected character: %q", c)
+		}
+	}
+	tokens = append(tokens, token{tokEOF, ""})
+	return tokens, nil
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) parseOr() (node, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "||" {
+		p.next()
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &orNode{l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "&&" {
+		p.next()
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &andNode{l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokOp && t.text == "!":
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{n: n}, nil
+	case t.kind == tokLParen:
+		p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokRParen {
+			return nil, fmt.Errorf("expected ')' but got %q", t.text)
+		}
+		return n, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	l, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	switch {
+	case t.kind == tokIdent && t.text == "in":
+		p.next()
+		r := p.next()
+		if r.kind != tokString {
+			return nil, fmt.Errorf("expected CIDR string after 'in' but got %q", r.text)
+		}
+		_, cidr, err := net.ParseCIDR(r.text)
+		if err != nil {
+			return nil, err
+		}
+		return &cmpNode{op: "in", l: l, cidr: cidr}, nil
+	case t.kind == tokOp && (t.text == "=~" || t.text == "!~"):
+		p.next()
+		r := p.next()
+		if r.kind != tokString {
+			return nil, fmt.Errorf("expected regexp string after %q but got %q", t.text, r.text)
+		}
+		re, err := regexp.Compile(r.text)
+		if err != nil {
+			return nil, err
+		}
+		return &cmpNode{op: t.text, l: l, re: re}, nil
+	case t.kind == tokOp && t.text != "&&" && t.text != "||" && t.text != "!":
+		p.next()
+		r, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &cmpNode{op: t.text, l: l, r: r, numeric: isNumberLiteral(l) || isNumberLiteral(r)}, nil
+	}
+	return &truthNode{o: l}, nil
+}
+
+func isNumberLiteral(o operand) bool {
+	l, ok := o.(*literalOperand)
+	if !ok {
+		return false
+	}
+	_, ok = l.v.(float64)
+	return ok
+}
+
+func (p *parser) parseOperand() (operand, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return &literalOperand{v: t.text}, nil
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number: %s", t.text)
+		}
+		return &literalOperand{v: f}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &literalOperand{v: true}, nil
+		case "false":
+			return &literalOperand{v: false}, nil
+		case "null":
+			return &literalOperand{v: nil}, nil
+		}
+		return &fieldOperand{path: t.text}, nil
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
@@ -0,0 +1,75 @@
+package trail
+
+import (
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+func TestFilter(t *testing.T) {
+	raw := `{"eventTime":"2022-02-03T14:05:00Z","eventSource":"iam.amazonaws.com","eventName":"DeleteUser","errorCode":"AccessDenied","readOnly":false,"sourceIPAddress":"192.0.2.10","userIdentity":{"type":"IAMUser","arn":"arn:aws:iam::123456789012:user/alice"},"resources":[{"ARN":"arn:aws:iam::123456789012:user/bob"}],"additionalEventData":{"bytesTransferredIn":42},"recipientAccountId":"012345678901","requestParameters":{"id":"12345678901234567"}}`
+	r := &Record{}
+	if err := json.Unmarshal([]byte(raw), r); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		expr    string
+		want    bool
+		wantErr bool
+	}{
+		{`eventSource == "iam.amazonaws.com"`, true, false},
+		{`eventSource == "iam.amazonaws.com" && errorCode != ""`, true, false},
+		{`eventSource == "s3.amazonaws.com" || errorCode == "AccessDenied"`, true, false},
+		{`eventSource == "s3.amazonaws.com" || errorCode == ""`, false, false},
+		{`errorMessage == ""`, true, false},
+		{`errorMessage != ""`, false, false},
+		{`eventName =~ "^(Delete|Put)"`, true, false},
+		{`eventName !~ "^Delete"`, false, false},
+		{`sourceIPAddress in "192.0.2.0/24"`, true, false},
+		{`sourceIPAddress in "198.51.100.0/24"`, false, false},
+		{`!(sourceIPAddress in "198.51.100.0/24")`, true, false},
+		{`userIdentity.arn == 'arn:aws:iam::123456789012:user/alice'`, true, false},
+		{`resources.0.ARN =~ ":user/bob$"`, true, false},
+		{`readOnly`, false, false},
+		{`!readOnly`, true, false},
+		{`readOnly == false`, true, false},
+		{`additionalEventData.bytesTransferredIn > 10`, true, false},
+		{`additionalEventData.bytesTransferredIn <= 10`, false, false},
+		{`eventTime >= "2022-02-03T14:00:00Z" && eventTime < "2022-02-03T15:00:00Z"`, true, false},
+		{`(eventName == "CreateUser" || eventName == "DeleteUser") && !readOnly`, true, false},
+		{`recipientAccountId == "012345678901"`, true, false},
+		{`recipientAccountId == "12345678901"`, false, false},
+		{`recipientAccountId == 12345678901`, true, false},
+		{`requestParameters.id == "12345678901234567"`, true, false},
+		{`requestParameters.id == "12345678901234568"`, false, false},
+		{`requestParameters.id != "12345678901234568"`, true, false},
+		{`eventSource ==`, false, true},
+		{`eventSource == "iam.amazonaws.com" &&`, false, true},
+		{`eventName =~ "("`, false, true},
+		{`sourceIPAddress in "invalid"`, false, true},
+		{`(eventSource == "iam.amazonaws.com"`, false, true},
+		{`eventSource == "iam.amazonaws.com")`, false, true},
+		{`eventSource == "iam.amazonaws.com`, false, true},
+	}
+	for _, tt := range tests {
+		f, err := CompileFilter(tt.expr)
+		if err != nil {
+			if !tt.wantErr {
+				t.Errorf("%s: %v", tt.expr, err)
+			}
+			continue
+		}
+		if tt.wantErr {
+			t.Errorf("%s: want error", tt.expr)
+			continue
+		}
+		got, err := f.Match(r)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %v want %v", tt.expr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package trail
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+const defaultFollowInterval = time.Minute
+
+// FollowEvents polls the trail log files of today (UTC) and calls fn for the events in newly delivered trail log files.
+// The events found by each poll are walked in order of timeline, and the event already walked is skipped by eventID.
+// The trail log files which exist when it starts are skipped. It rolls over to the next day at midnight UTC.
+// It returns nil when ctx is canceled.
+func FollowEvents(ctx context.Context, sess *session.Session, dsn string, opt Option, interval time.Duration, fn WalkEventsFunc) error {
+	var f *Filter
+	if opt.Filter != "" {
+		var err error
+		f, err = CompileFilter(opt.Filter)
+		if err != nil {
+			return err
+		}
+	}
+	s, prefix, err := NewStorage(sess, dsn)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = s.Close()
+	}()
+	if interval <= 0 {
+		interval = defaultFollowInterval
+	}
+	fl := &follower{
+		sess:    sess,
+		s:       s,
+		prefix:  prefix,
+		opt:     opt,
+		filter:  f,
+		fn:      fn,
+		sem:     semaphore.NewWeighted(concurrency(opt)),
+		retries: maxRetries(opt),
+		seen:    map[string]map[string]bool{},
+		last:    map[string]time.Time{},
+		events:  map[string]bool{},
+	}
+	for i := 0; ; i++ {
+		if err := fl.poll(ctx, time.Now().UTC(), i == 0); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+type follower struct {
+	sess    *session.Session
+	s       Storage
+	prefix  string
+	opt     Option
+	filter  *Filter
+	fn      WalkEventsFunc
+	sem     *semaphore.Weighted
+	retries int
+
+	day string
+	// prefixes are the prefixes of the day, and prevPrefixes are the prefixes of the previous day polled once more after rolling over
+	prefixes     []string
+	prevPrefixes []string
+	// seen are the keys of trail log files already found per prefix, and last is the latest delivery time of them
+	seen map[string]map[string]bool
+	last map[string]time.Time
+	// events and prevEvents are the eventIDs already walked on the day and the previous day
+	events     map[string]bool
+	prevEvents map[string]bool
+}
+
+// poll finds trail log files newly delivered and walks the events in them.
+// If skip is true, the trail log files found are only recorded as seen.
+func (fl *follower) poll(ctx context.Context, now time.Time, skip bool) error {
+	day := now.Format(datePathFormat)
+	if day != fl.day {
+		o := fl.opt
+		o.DatePath = day
+		o.StartDatePath = ""
+		o.EndDatePath = ""
+		o.Since = time.Time{}
+		o.Until = time.Time{}
+		prefixes, err := generatePrefixes(ctx, fl.sess, fl.s, fl.prefix, o, false)
+		if err != nil {
+			return err
+		}
+		if fl.day != "" {
+			log.Info().Str("day", day).Msg("Rolling over to the next day")
+		}
+		fl.day = day
+		fl.prevPrefixes = fl.prefixes
+		fl.prefixes = prefixes[0].prefixes
+		fl.prevEvents = fl.events
+		fl.events = map[string]bool{}
+	}
+	targets := append(append([]string{}, fl.prevPrefixes...), fl.prefixes...)
+	keys, err := fl.list(ctx, targets)
+	if err != nil {
+		return err
+	}
+	// The previous day is polled only once after rolling over
+	for _, p := range fl.prevPrefixes {
+		delete(fl.seen, p)
+		delete(fl.last, p)
+	}
+	fl.prevPrefixes = nil
+	if skip || len(keys) == 0 {
+		return nil
+	}
+	records, err := fl.get(ctx, keys)
+	if err != nil {
+		return err
+	}
+	return fl.walk(records)
+}
+
+// list returns the keys of trail log files newly found under the prefixes
+func (fl *follower) list(ctx context.Context, prefixes []string) ([]string, error) {
+	keys := []string{}
+	// last is updated after all listings finish because startAfter is read from it while they are running
+	last := map[string]time.Time{}
+	var mu sync.Mutex
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, p := range prefixes {
+		if fl.seen[p] == nil {
+			fl.seen[p] = map[string]bool{}
+		}
+		seen := fl.seen[p]
+		startAfter := ""
+		if l, ok := fl.last[p]; ok {
+			startAfter = (&deliveryWindow{since: l}).startAfter(p)
+		}
+		func(p string) {
+			eg.Go(func() error {
+				if err := fl.sem.Acquire(ctx, 1); err != nil {
+					return err
+				}
+				defer fl.sem.Release(1)
+				l := time.Time{}
+				if err := fl.s.ListObjects(ctx, p, startAfter, func(o *s3.Object) error {
+					key := *o.Key
+					if seen[key] {
+						return nil
+					}
+					seen[key] = true
+					if k, err := ParseObjectKey(key); err == nil && k.Delivered.After(l) {
+						l = k.Delivered
+					}
+					mu.Lock()
+					keys = append(keys, key)
+					mu.Unlock()
+					return nil
+				}); err != nil {
+					return err
+				}
+				mu.Lock()
+				last[p] = l
+				mu.Unlock()
+				return nil
+			})
+		}(p)
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	for p, l := range last {
+		if l.After(fl.last[p]) {
+			fl.last[p] = l
+		}
+	}
+	return keys, nil
+}
+
+// get gets the trail log files and returns the records in them
+func (fl *follower) get(ctx context.Context, keys []string) ([]*Record, error) {
+	records := []*Record{}
+	var mu sync.Mutex
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, key := range keys {
+		if err := fl.sem.Acquire(ctx, 1); err != nil {
+			return nil, err
+		}
+		key := key
+		eg.Go(func() error {
+			defer fl.sem.Release(1)
+			log.Info().Str("key", key).Msg("Digging trail log")
+			var td *LogData
+			if err := retry(ctx, fl.retries, func() error {
+				var err error
+				td, err = getLogData(ctx, fl.s, key)
+				return err
+			}); err != nil {
+				return err
+			}
+			mu.Lock()
+			records = append(records, td.Records...)
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// walk calls fn for the records not walked yet in order of timeline
+func (fl *follower) walk(records []*Record) error {
+	targets := []*Record{}
+	for _, r := range records {
+		if fl.events[r.EventID] || fl.prevEvents[r.EventID] {
+			continue
+		}
+		fl.events[r.EventID] = true
+		if fl.filter != nil {
+			m, err := fl.filter.Match(r)
+			if err != nil {
+				return err
+			}
+			if !m {
+				continue
+			}
+		}
+		targets = append(targets, r)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return eventKey(targets[i]) < eventKey(targets[j])
+	})
+	for _, r := range targets {
+		if err := fl.fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package trail
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sync/semaphore"
+)
+
+func TestFollowerPoll(t *testing.T) {
+	dir := t.TempDir()
+	d := time.Date(2022, 2, 3, 0, 0, 0, 0, time.UTC)
+	a := "123456789012"
+	r := "ap-northeast-1"
+	writeTrailLog(t, dir, a, r, d.Add(10*time.Hour), testRecord("a", d.Add(9*time.Hour+55*time.Minute)))
+	s, prefix, err := NewStorage(nil, "file://"+dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	fl := &follower{
+		s:       s,
+		prefix:  prefix,
+		opt:     Option{},
+		fn:      func(r *Record) error { got = append(got, r.EventID); return nil },
+		sem:     semaphore.NewWeighted(4),
+		seen:    map[string]map[string]bool{},
+		last:    map[string]time.Time{},
+		events:  map[string]bool{},
+		retries: 0,
+	}
+	tests := []struct {
+		logs func()
+		now  time.Time
+		skip bool
+		want []string
+	}{
+		{
+			func() {},
+			d.Add(10*time.Hour + 30*time.Minute),
+			true,
+			[]string{},
+		},
+		{
+			func() {
+				writeTrailLog(t, dir, a, r, d.Add(10*time.Hour+35*time.Minute), testRecord("c", d.Add(10*time.Hour+32*time.Minute)), testRecord("b", d.Add(10*time.Hour+31*time.Minute)))
+			},
+			d.Add(10*time.Hour + 40*time.Minute),
+			false,
+			[]string{"b", "c"},
+		},
+		{
+			func() {
+				// c is delivered again
+				writeTrailLog(t, dir, a, r, d.Add(10*time.Hour+45*time.Minute), testRecord("c", d.Add(10*time.Hour+32*time.Minute)), testRecord("d", d.Add(10*time.Hour+41*time.Minute)))
+			},
+			d.Add(10*time.Hour + 50*time.Minute),
+			false,
+			[]string{"d"},
+		},
+		{
+			func() {
+				writeTrailLog(t, dir, a, r, d.Add(23*time.Hour+59*time.Minute), testRecord("e", d.Add(23*time.Hour+55*time.Minute)))
+				writeTrailLog(t, dir, a, r, d.Add(24*time.Hour+1*time.Minute), testRecord("f", d.Add(23*time.Hour+58*time.Minute)))
+			},
+			d.Add(24*time.Hour + 2*time.Minute),
+			false,
+			[]string{"e", "f"},
+		},
+		{
+			func() {},
+			d.Add(24*time.Hour + 10*time.Minute),
+			false,
+			[]string{},
+		},
+	}
+	for i, tt := range tests {
+		tt.logs()
+		got = []string{}
+		if err := fl.poll(context.Background(), tt.now, tt.skip); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, tt.want, nil); diff != "" {
+			t.Errorf("poll %d: %s", i, diff)
+		}
+	}
+}
+
+func TestFollowerPollMultiplePrefixes(t *testing.T) {
+	dir := t.TempDir()
+	d := time.Date(2022, 2, 3, 0, 0, 0, 0, time.UTC)
+	a := "123456789012"
+	regions := []string{}
+	for i := 0; i < 30; i++ {
+		r := fmt.Sprintf("region-%02d", i)
+		regions = append(regions, r)
+		writeTrailLog(t, dir, a, r, d.Add(10*time.Hour), testRecord("old-"+r, d.Add(9*time.Hour+55*time.Minute)))
+	}
+	s, prefix, err := NewStorage(nil, "file://"+dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	fl := &follower{
+		s:      s,
+		prefix: prefix,
+		opt:    Option{},
+		fn:     func(r *Record) error { got = append(got, r.EventID); return nil },
+		sem:    semaphore.NewWeighted(8),
+		seen:   map[string]map[string]bool{},
+		last:   map[string]time.Time{},
+		events: map[string]bool{},
+	}
+	if err := fl.poll(context.Background(), d.Add(10*time.Hour+30*time.Minute), true); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		delivered := d.Add(time.Duration(11+i) * time.Hour)
+		want := []string{}
+		for j, r := range regions {
+			id := fmt.Sprintf("%d-%s", i, r)
+			want = append(want, id)
+			writeTrailLog(t, dir, a, r, delivered, testRecord(id, delivered.Add(-time.Duration(len(regions)-j)*time.Second)))
+		}
+		got = []string{}
+		if err := fl.poll(context.Background(), delivered.Add(time.Minute), false); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, want, nil); diff != "" {
+			t.Errorf("poll %d: %s", i, diff)
+		}
+	}
+}
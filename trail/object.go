@@ -1,50 +1,112 @@
 package trail
 
 import (
-	"github.com/aws/aws-sdk-go/aws"
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 )
 
+var keyRe = regexp.MustCompile(`(?:^|/)(?:(o-[a-z0-9]+)/)?([0-9]+)/CloudTrail/([a-z0-9\-]+)/([0-9]{4}/[0-9]{2}/[0-9]{2})/(?:[^/]*_([0-9]{8}T[0-9]{4}Z)_)?[^/]+$`)
+
+const deliveredFormat = "20060102T1504Z"
+
+// ObjectKey is the information in the key of a trail log file
+type ObjectKey struct {
+	Organization string
+	AccountID    string
+	Region       string
+	Day          time.Time
+	// Delivered is the time in the file name when the trail log file was delivered (minute precision)
+	Delivered time.Time
+}
+
+// ParseObjectKey parses the key of a trail log file
+// (eg. AWSLogs/1234567890/CloudTrail/ap-northeast-1/2022/02/03/xxx.json.gz, AWSLogs/o-xxxxxxxxxx/1234567890/CloudTrail/...)
+func ParseObjectKey(key string) (*ObjectKey, error) {
+	matches := keyRe.FindStringSubmatch(key)
+	if matches == nil {
+		return nil, fmt.Errorf("invalid trail log key: %s", key)
+	}
+	day, err := time.Parse(datePathFormat, matches[4])
+	if err != nil {
+		return nil, fmt.Errorf("invalid trail log key: %s", key)
+	}
+	var delivered time.Time
+	if matches[5] != "" {
+		delivered, err = time.Parse(deliveredFormat, matches[5])
+		if err != nil {
+			return nil, fmt.Errorf("invalid trail log key: %s", key)
+		}
+	}
+	return &ObjectKey{
+		Organization: matches[1],
+		AccountID:    matches[2],
+		Region:       matches[3],
+		Day:          day,
+		Delivered:    delivered,
+	}, nil
+}
+
 type WalkObjectsFunc func(o *s3.Object) error
 
+// WalkObjects walks the trail log objects
 func WalkObjects(sess *session.Session, dsn string, opt Option, fn WalkObjectsFunc) error {
-	bucket, prefixes, err := generatePrefixes(sess, dsn, opt, false)
+	return WalkObjectsWithContext(context.Background(), sess, dsn, opt, fn)
+}
+
+// WalkObjectsWithContext walks the trail log objects.
+// When ctx is canceled, it stops listing objects and returns the error of ctx.
+func WalkObjectsWithContext(ctx context.Context, sess *session.Session, dsn string, opt Option, fn WalkObjectsFunc) error {
+	s, prefix, err := NewStorage(sess, dsn)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = s.Close()
+	}()
+	prefixes, err := generatePrefixes(ctx, sess, s, prefix, opt, false)
 	if err != nil {
 		return err
 	}
-	s3c := s3.New(sess)
-	var t *string
+	// Trail log files are pruned only if the range of event time is specified
+	var w *deliveryWindow
+	if !opt.Since.IsZero() {
+		since, until, err := timeRange(opt)
+		if err != nil {
+			return err
+		}
+		w = newDeliveryWindow(opt, since, until)
+	}
+	sem := semaphore.NewWeighted(concurrency(opt))
 	for _, pd := range prefixes {
-		eg := errgroup.Group{}
+		eg, ctx := errgroup.WithContext(ctx)
 		for _, prefix := range pd.prefixes {
-			log.Info().Str("prefix", prefix).Msg("Digging trail logs")
-			func(bucket, prefix string) {
+			func(prefix string) {
 				eg.Go(func() error {
-					for {
-						o, err := s3c.ListObjectsV2(&s3.ListObjectsV2Input{
-							Bucket:            aws.String(bucket),
-							Prefix:            aws.String(prefix),
-							ContinuationToken: t,
-						})
-						if err != nil {
-							return err
-						}
-						for _, c := range o.Contents {
-							if err := fn(c); err != nil {
-								return err
-							}
+					if err := sem.Acquire(ctx, 1); err != nil {
+						return err
+					}
+					defer sem.Release(1)
+					log.Info().Str("prefix", prefix).Msg("Digging trail logs")
+					return s.ListObjects(ctx, prefix, w.startAfter(prefix), func(o *s3.Object) error {
+						skip, stop := w.check(*o.Key)
+						if stop {
+							return errStopListing
 						}
-						if o.NextContinuationToken == nil {
-							break
+						if skip {
+							return nil
 						}
-						t = o.NextContinuationToken
-					}
-					return nil
+						return fn(o)
+					})
 				})
-			}(bucket, prefix)
+			}(prefix)
 		}
 		if err := eg.Wait(); err != nil {
 			return err
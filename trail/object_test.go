@@ -0,0 +1,60 @@
+package trail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseObjectKey(t *testing.T) {
+	d := time.Date(2022, 2, 3, 0, 0, 0, 0, time.UTC)
+	dt := time.Date(2022, 2, 3, 14, 5, 0, 0, time.UTC)
+	tests := []struct {
+		key     string
+		want    *ObjectKey
+		wantErr bool
+	}{
+		{
+			"AWSLogs/123456789012/CloudTrail/ap-northeast-1/2022/02/03/123456789012_CloudTrail_ap-northeast-1_20220203T1405Z_abcdefghijklmnop.json.gz",
+			&ObjectKey{AccountID: "123456789012", Region: "ap-northeast-1", Day: d, Delivered: dt},
+			false,
+		},
+		{
+			"AWSLogs/o-abcdefghij/123456789012/CloudTrail/ap-southeast-4/2022/02/03/123456789012_CloudTrail_ap-southeast-4_20220203T1405Z_abcdefghijklmnop.json.gz",
+			&ObjectKey{Organization: "o-abcdefghij", AccountID: "123456789012", Region: "ap-southeast-4", Day: d, Delivered: dt},
+			false,
+		},
+		{
+			"prefix/AWSLogs/123456789012/CloudTrail/us-gov-west-1/2022/02/03/123456789012_CloudTrail_us-gov-west-1_20220203T1405Z_abcdefghijklmnop.json.gz",
+			&ObjectKey{AccountID: "123456789012", Region: "us-gov-west-1", Day: d, Delivered: dt},
+			false,
+		},
+		{
+			"AWSLogs/123456789012/CloudTrail/ap-northeast-1/2022/02/03/renamed.json.gz",
+			&ObjectKey{AccountID: "123456789012", Region: "ap-northeast-1", Day: d},
+			false,
+		},
+		{
+			"AWSLogs/123456789012/CloudTrail-Digest/ap-northeast-1/2022/02/03/123456789012_CloudTrail-Digest_ap-northeast-1_trail_ap-northeast-1_20220203T140503Z.json.gz",
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		got, err := ParseObjectKey(tt.key)
+		if err != nil {
+			if !tt.wantErr {
+				t.Error(err)
+			}
+			continue
+		}
+		if tt.wantErr {
+			t.Errorf("%s: want error", tt.key)
+			continue
+		}
+		if diff := cmp.Diff(got, tt.want, nil); diff != "" {
+			t.Errorf("%s", diff)
+		}
+	}
+}
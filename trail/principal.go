@@ -0,0 +1,27 @@
+package trail
+
+import "strings"
+
+// MatchPrincipal reports whether the event is called by the principal.
+// principal is the ARN of the IAM user, the role or the role session, the access key ID or the principal ID.
+// The role (eg. arn:aws:iam::123456789012:role/name) also matches its sessions as the session issuer,
+// and the assumed role without the session name (eg. arn:aws:sts::123456789012:assumed-role/name) matches all its sessions.
+func MatchPrincipal(r *Record, principal string) bool {
+	if principal == "" {
+		return false
+	}
+	u := r.UserIdentity
+	i := u.SessionContext.SessionIssuer
+	switch principal {
+	case u.Arn, u.AccessKeyID, u.PrincipalID, i.Arn, i.PrincipalID:
+		return true
+	}
+	// eg. AROAXXXXXXXXXXXXXXXXX:session-name
+	if strings.HasPrefix(u.PrincipalID, principal+":") {
+		return true
+	}
+	if strings.Contains(principal, ":assumed-role/") && strings.HasPrefix(u.Arn, principal+"/") {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package trail
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultConcurrency = 16
+	defaultMaxRetries  = 5
+	retryBaseDelay     = 200 * time.Millisecond
+	retryMaxDelay      = 20 * time.Second
+)
+
+func concurrency(opt Option) int64 {
+	if opt.Concurrency <= 0 {
+		return defaultConcurrency
+	}
+	return int64(opt.Concurrency)
+}
+
+func maxRetries(opt Option) int {
+	if opt.MaxRetries < 0 {
+		return 0
+	}
+	if opt.MaxRetries == 0 {
+		return defaultMaxRetries
+	}
+	return opt.MaxRetries
+}
+
+// retry calls fn until it succeeds, returns an error which is not retryable or reaches maxRetries.
+// The interval of retries increases exponentially.
+func retry(ctx context.Context, maxRetries int, fn func() error) error {
+	for i := 0; ; i++ {
+		err := fn()
+		if err == nil || i >= maxRetries || !isRetryable(err) {
+			return err
+		}
+		d := backoff(i)
+		log.Warn().Err(err).Int("retry", i+1).Dur("wait", d).Msg("Retrying")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+}
+
+func backoff(attempt int) time.Duration {
+	d := retryBaseDelay << uint(attempt)
+	if d <= 0 || d > retryMaxDelay {
+		return retryMaxDelay
+	}
+	return d
+}
+
+// isRetryable reports whether err is caused by throttling, server errors or transient network errors
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var rf awserr.RequestFailure
+	if errors.As(err, &rf) {
+		if rf.StatusCode() == http.StatusTooManyRequests || rf.StatusCode() >= http.StatusInternalServerError {
+			return true
+		}
+	}
+	// request.IsErrorRetryable treats unknown errors as retryable, so it is applied to AWS errors only
+	var ae awserr.Error
+	if errors.As(err, &ae) && (request.IsErrorThrottle(ae) || request.IsErrorRetryable(ae)) {
+		return true
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
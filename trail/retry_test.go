@@ -0,0 +1,118 @@
+package trail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{awserr.NewRequestFailure(awserr.New("SlowDown", "Please reduce your request rate.", nil), http.StatusServiceUnavailable, "xxx"), true},
+		{awserr.NewRequestFailure(awserr.New("InternalError", "We encountered an internal error.", nil), http.StatusInternalServerError, "xxx"), true},
+		{awserr.NewRequestFailure(awserr.New("TooManyRequests", "", nil), http.StatusTooManyRequests, "xxx"), true},
+		{awserr.New("ThrottlingException", "Rate exceeded", nil), true},
+		{awserr.NewRequestFailure(awserr.New("NoSuchKey", "The specified key does not exist.", nil), http.StatusNotFound, "xxx"), false},
+		{awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), http.StatusForbidden, "xxx"), false},
+		{fmt.Errorf("failed to read: %w", context.Canceled), false},
+		{errors.New("invalid character"), false},
+	}
+	for _, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.want {
+			t.Errorf("%v: got %v want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	throttle := awserr.NewRequestFailure(awserr.New("SlowDown", "Please reduce your request rate.", nil), http.StatusServiceUnavailable, "xxx")
+	notFound := awserr.NewRequestFailure(awserr.New("NoSuchKey", "The specified key does not exist.", nil), http.StatusNotFound, "xxx")
+	tests := []struct {
+		errs       []error
+		maxRetries int
+		wantCalls  int
+		wantErr    error
+	}{
+		{[]error{nil}, 3, 1, nil},
+		{[]error{throttle, throttle, nil}, 3, 3, nil},
+		{[]error{throttle, throttle, throttle}, 2, 3, throttle},
+		{[]error{notFound, nil}, 3, 1, notFound},
+		{[]error{throttle, nil}, 0, 1, throttle},
+	}
+	for _, tt := range tests {
+		calls := 0
+		err := retry(context.Background(), tt.maxRetries, func() error {
+			err := tt.errs[calls]
+			calls += 1
+			return err
+		})
+		if calls != tt.wantCalls {
+			t.Errorf("got %v want %v", calls, tt.wantCalls)
+		}
+		if err != tt.wantErr {
+			t.Errorf("got %v want %v", err, tt.wantErr)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := retry(ctx, 3, func() error {
+		return throttle
+	}); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v want %v", err, context.Canceled)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	got := []time.Duration{}
+	for i := 0; i < 10; i++ {
+		got = append(got, backoff(i))
+	}
+	want := []time.Duration{
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		1600 * time.Millisecond,
+		3200 * time.Millisecond,
+		6400 * time.Millisecond,
+		12800 * time.Millisecond,
+		20 * time.Second,
+		20 * time.Second,
+		20 * time.Second,
+	}
+	if diff := cmp.Diff(got, want, nil); diff != "" {
+		t.Errorf("%s", diff)
+	}
+}
+
+func TestWalkEventsConcurrency(t *testing.T) {
+	dir := t.TempDir()
+	d := time.Date(2022, 2, 3, 0, 0, 0, 0, time.UTC)
+	want := []string{}
+	for i := 0; i < 50; i++ {
+		id := fmt.Sprintf("%03d", i)
+		writeTrailLog(t, dir, "123456789012", "ap-northeast-1", d.Add(time.Duration(i)*time.Minute+time.Second), testRecord(id, d.Add(time.Duration(i)*time.Minute)))
+		want = append(want, id)
+	}
+	for _, c := range []int{1, 4, 64} {
+		got := []string{}
+		if err := WalkEvents(nil, "file://"+dir, Option{DatePath: "2022/02/03", Concurrency: c}, func(r *Record) error {
+			got = append(got, r.EventID)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, want, nil); diff != "" {
+			t.Errorf("concurrency %d: %s", c, diff)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package trail
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/goccy/go-json"
+	"github.com/rs/zerolog/log"
+)
+
+// recordOverhead is the estimated bytes of a decoded record in memory other than the raw JSON
+const recordOverhead = 512
+
+// eventSorter sorts the events of a day in order of key.
+// When the estimated size of the events in memory exceeds maxMemory, it spills them to a temporary file as a sorted run,
+// and merges the runs when walking.
+type eventSorter struct {
+	mu        sync.Mutex
+	m         map[string]*Record
+	size      int64
+	maxMemory int64
+	dir       string
+	runs      []string
+}
+
+// newEventSorter returns a eventSorter. If maxMemory <= 0, all events are held in memory.
+func newEventSorter(dir string, maxMemory int64) *eventSorter {
+	return &eventSorter{
+		m:         map[string]*Record{},
+		maxMemory: maxMemory,
+		dir:       dir,
+	}
+}
+
+// store stores the record with the key. A record with the same key overwrites the stored one.
+// It may be called concurrently.
+func (s *eventSorter) store(k string, r *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.m[k]; ok {
+		s.m[k] = r
+		return nil
+	}
+	s.m[k] = r
+	if s.maxMemory <= 0 {
+		return nil
+	}
+	s.size += int64(len(r.Raw)*2 + recordOverhead)
+	if s.size < s.maxMemory {
+		return nil
+	}
+	return s.spill()
+}
+
+// spill writes the events in memory to a temporary file in order of key
+func (s *eventSorter) spill() error {
+	f, err := os.CreateTemp(s.dir, "run-")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f.Name())
+	w := bufio.NewWriter(f)
+	for _, k := range s.sortedKeys() {
+		b, err := s.m[k].MarshalRaw()
+		if err == nil {
+			err = writeChunk(w, []byte(k))
+		}
+		if err == nil {
+			err = writeChunk(w, b)
+		}
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Debug().Str("run", f.Name()).Int("events", len(s.m)).Msg("Spilled events to temporary file")
+	s.m = map[string]*Record{}
+	s.size = 0
+	return nil
+}
+
+// walk calls fn for each event in order of key until fn returns false
+func (s *eventSorter) walk(fn func(r *Record) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.runs) == 0 {
+		for _, k := range s.sortedKeys() {
+			if !fn(s.m[k]) {
+				break
+			}
+		}
+		return nil
+	}
+	if len(s.m) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	return s.merge(fn)
+}
+
+func (s *eventSorter) sortedKeys() []string {
+	keys := make([]string, 0, len(s.m))
+	for k := range s.m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// merge merges the sorted runs. Events with the same key are walked once.
+func (s *eventSorter) merge(fn func(r *Record) bool) error {
+	h := &runHeap{}
+	defer func() {
+		for _, rr := range *h {
+			_ = rr.f.Close()
+		}
+	}()
+	for i, p := range s.runs {
+		f, err := os.Open(filepath.Clean(p))
+		if err != nil {
+			return err
+		}
+		rr := &runReader{f: f, r: bufio.NewReader(f), idx: i}
+		ok, err := rr.next()
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+		if !ok {
+			_ = f.Close()
+			continue
+		}
+		heap.Push(h, rr)
+	}
+	var (
+		last    string
+		started bool
+	)
+	for h.Len() > 0 {
+		rr := (*h)[0]
+		if !started || rr.key != last {
+			started = true
+			last = rr.key
+			r := &Record{}
+			if err := json.Unmarshal(rr.raw, r); err != nil {
+				return err
+			}
+			if !fn(r) {
+				return nil
+			}
+		}
+		ok, err := rr.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+			continue
+		}
+		_ = rr.f.Close()
+		heap.Pop(h)
+	}
+	return nil
+}
+
+// close removes the temporary files of the runs
+func (s *eventSorter) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for _, p := range s.runs {
+		if e := os.Remove(p); e != nil && !os.IsNotExist(e) {
+			err = e
+		}
+	}
+	s.runs = nil
+	return err
+}
+
+type runReader struct {
+	f   *os.File
+	r   *bufio.Reader
+	idx int
+	key string
+	raw []byte
+}
+
+// next reads the next event of the run. It returns false at the end of the run.
+func (rr *runReader) next() (bool, error) {
+	k, err := readChunk(rr.r)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	raw, err := readChunk(rr.r)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return false, io.ErrUnexpectedEOF
+		}
+		return false, err
+	}
+	rr.key = string(k)
+	rr.raw = raw
+	return true, nil
+}
+
+// writeChunk writes the length-prefixed bytes
+func writeChunk(w io.Writer, b []byte) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(len(b)))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// readChunk reads the length-prefixed bytes
+func readChunk(r *bufio.Reader) ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+type runHeap []*runReader
+
+func (h runHeap) Len() int { return len(h) }
+
+func (h runHeap) Less(i, j int) bool {
+	if h[i].key == h[j].key {
+		return h[i].idx > h[j].idx
+	}
+	return h[i].key < h[j].key
+}
+
+func (h runHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runReader)) }
+
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
@@ -0,0 +1,129 @@
+package trail
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEventSorter(t *testing.T) {
+	d := time.Date(2022, 2, 3, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		maxMemory int64
+		wantRuns  bool
+	}{
+		{0, false},
+		{1 << 30, false},
+		{4096, true},
+		{1, true},
+	}
+	for _, tt := range tests {
+		s := newEventSorter(t.TempDir(), tt.maxMemory)
+		want := []string{}
+		for i := 0; i < 100; i++ {
+			// stored in reverse order of timeline
+			id := fmt.Sprintf("%03d", 99-i)
+			r := &Record{}
+			if err := r.UnmarshalJSON([]byte(testRecord(id, d.Add(time.Duration(99-i)*time.Second)))); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.store(eventKey(r), r); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, fmt.Sprintf("%03d", i))
+		}
+		// the same key is walked once
+		dup := &Record{}
+		if err := dup.UnmarshalJSON([]byte(testRecord("050", d.Add(50*time.Second)))); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.store(eventKey(dup), dup); err != nil {
+			t.Fatal(err)
+		}
+		if got := len(s.runs) > 0; got != tt.wantRuns {
+			t.Errorf("maxMemory %d: got runs %v want %v", tt.maxMemory, got, tt.wantRuns)
+		}
+		got := []string{}
+		if err := s.walk(func(r *Record) bool {
+			got = append(got, r.EventID)
+			return true
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, want, nil); diff != "" {
+			t.Errorf("maxMemory %d: %s", tt.maxMemory, diff)
+		}
+		if err := s.close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestWalkEventsMaxMemory(t *testing.T) {
+	dir := t.TempDir()
+	d := time.Date(2022, 2, 3, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		records := []string{}
+		for j := 0; j < 10; j++ {
+			records = append(records, testRecord(fmt.Sprintf("%02d-%02d", i, j), d.Add(time.Duration(j*20+i)*time.Minute)))
+		}
+		writeTrailLog(t, dir, "123456789012", "ap-northeast-1", d.Add(time.Duration(i)*time.Hour), records...)
+	}
+	walk := func(maxMemory int64) []string {
+		got := []string{}
+		if err := WalkEvents(nil, "file://"+dir, Option{DatePath: "2022/02/03", MaxMemory: maxMemory}, func(r *Record) error {
+			got = append(got, r.EventID)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	want := walk(0)
+	if len(want) != 200 {
+		t.Fatalf("got %v want %v", len(want), 200)
+	}
+	if diff := cmp.Diff(walk(8192), want, nil); diff != "" {
+		t.Errorf("%s", diff)
+	}
+}
+
+func TestWalkEventsSameTimestamp(t *testing.T) {
+	dir := t.TempDir()
+	d := time.Date(2022, 2, 3, 12, 0, 0, 0, time.UTC)
+	n := 5000
+	for i := 0; i < 5; i++ {
+		records := []string{}
+		for j := i; j < n; j += 5 {
+			records = append(records, testRecord(fmt.Sprintf("%05d", n-j), d))
+		}
+		writeTrailLog(t, dir, "123456789012", "ap-northeast-1", d.Add(time.Duration(i+1)*time.Minute), records...)
+	}
+	// sub-second precision
+	writeTrailLog(t, dir, "123456789012", "ap-northeast-1", d.Add(10*time.Minute),
+		testRecord("zzz", d.Add(-time.Millisecond)),
+		testRecord("aaa", d.Add(time.Millisecond)),
+	)
+	// the same event delivered twice
+	writeTrailLog(t, dir, "123456789012", "ap-northeast-1", d.Add(11*time.Minute), testRecord("aaa", d.Add(time.Millisecond)))
+
+	want := []string{"zzz"}
+	for i := 1; i <= n; i++ {
+		want = append(want, fmt.Sprintf("%05d", i))
+	}
+	want = append(want, "aaa")
+	for _, maxMemory := range []int64{0, 64 * 1024} {
+		got := []string{}
+		if err := WalkEvents(nil, "file://"+dir, Option{DatePath: "2022/02/03", MaxMemory: maxMemory}, func(r *Record) error {
+			got = append(got, r.EventID)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, want, nil); diff != "" {
+			t.Errorf("maxMemory %d: %s", maxMemory, diff)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package trail
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const defaultPrefix = "AWSLogs"
+
+// errStopListing is returned by WalkObjectsFunc to stop listing objects
+var errStopListing = errors.New("stop listing objects")
+
+// Storage is a store of trail log files which have the same layout as the S3 bucket of AWS CloudTrail
+type Storage interface {
+	// ListObjects calls fn for each object under the prefix after startAfter in order of key.
+	// If startAfter is empty, all objects under the prefix are listed. If fn returns errStopListing, it stops listing without error.
+	ListObjects(ctx context.Context, prefix, startAfter string, fn WalkObjectsFunc) error
+	// ListCommonPrefixes returns the names of "directories" directly under the prefix
+	ListCommonPrefixes(ctx context.Context, prefix string) ([]string, error)
+	// GetObject returns the content of the object
+	GetObject(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetObjectMetadata returns the user-defined metadata of the object (eg. signature of the digest file) with lowercase keys
+	GetObjectMetadata(ctx context.Context, key string) (map[string]string, error)
+	// Close releases the resources of the storage
+	Close() error
+}
+
+// NewStorage returns the Storage and the root prefix of trail logs (eg. AWSLogs) for dsn.
+//
+//	s3://bucket/prefix
+//	file:///path/to/AWSLogs
+//	file:///path/to/trail.tar.gz
+func NewStorage(sess *session.Session, dsn string) (Storage, string, error) {
+	switch {
+	case strings.HasPrefix(dsn, "s3://"):
+		splitted := strings.SplitN(strings.TrimPrefix(dsn, "s3://"), "/", 2)
+		if len(splitted) == 0 || splitted[0] == "" {
+			return nil, "", fmt.Errorf("invalid s3 bucket url: %s", dsn)
+		}
+		prefix := defaultPrefix
+		if len(splitted) > 1 && strings.Trim(splitted[1], "/") != "" {
+			prefix = strings.Trim(splitted[1], "/")
+		}
+		return &s3Storage{s3c: s3.New(sess), bucket: splitted[0]}, prefix, nil
+	case strings.HasPrefix(dsn, "file://"):
+		p := filepath.Clean(strings.TrimPrefix(dsn, "file://"))
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, "", err
+		}
+		if fi.IsDir() {
+			root, prefix := splitLogsDir(p)
+			return &dirStorage{root: root}, prefix, nil
+		}
+		return newTarStorage(p)
+	}
+	return nil, "", fmt.Errorf("invalid trail log url: %s", dsn)
+}
+
+// splitLogsDir splits the directory of trail logs into the root directory of the storage and the prefix
+func splitLogsDir(p string) (string, string) {
+	if fi, err := os.Stat(filepath.Join(p, defaultPrefix)); err == nil && fi.IsDir() {
+		return p, defaultPrefix
+	}
+	return filepath.Dir(p), filepath.Base(p)
+}
+
+// decompress returns the reader of the content of rc decompressed if it is gzipped
+func decompress(rc io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(rc)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		_ = rc.Close()
+		return nil, err
+	}
+	if len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return &readCloser{Reader: br, closers: []io.Closer{rc}}, nil
+	}
+	gr, err := gzip.NewReader(br)
+	if err != nil {
+		_ = rc.Close()
+		return nil, err
+	}
+	return &readCloser{Reader: gr, closers: []io.Closer{gr, rc}}, nil
+}
+
+// readObject reads the whole content of the object decompressing it if it is gzipped
+func readObject(ctx context.Context, s Storage, key string) ([]byte, error) {
+	rc, err := s.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	body, err := decompress(rc)
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if _, err := io.Copy(buf, body); err != nil {
+		_ = body.Close()
+		return nil, err
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *readCloser) Close() error {
+	var err error
+	for _, c := range r.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+type s3Storage struct {
+	s3c    *s3.S3
+	bucket string
+}
+
+func (s *s3Storage) ListObjects(ctx context.Context, prefix, startAfter string, fn WalkObjectsFunc) error {
+	i := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}
+	if startAfter != "" {
+		i.StartAfter = aws.String(startAfter)
+	}
+	var ferr error
+	if err := s.s3c.ListObjectsV2PagesWithContext(ctx, i, func(o *s3.ListObjectsV2Output, _ bool) bool {
+		for _, c := range o.Contents {
+			if err := fn(c); err != nil {
+				ferr = err
+				return false
+			}
+		}
+		return true
+	}); err != nil {
+		return err
+	}
+	if errors.Is(ferr, errStopListing) {
+		return nil
+	}
+	return ferr
+}
+
+func (s *s3Storage) ListCommonPrefixes(ctx context.Context, prefix string) ([]string, error) {
+	prefix = fmt.Sprintf("%s/", strings.TrimSuffix(prefix, "/"))
+	names := []string{}
+	if err := s.s3c.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(o *s3.ListObjectsV2Output, _ bool) bool {
+		for _, p := range o.CommonPrefixes {
+			names = append(names, strings.Trim(strings.TrimPrefix(*p.Prefix, prefix), "/"))
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+func (s *s3Storage) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.s3c.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return obj.Body, nil
+}
+
+func (s *s3Storage) GetObjectMetadata(ctx context.Context, key string) (map[string]string, error) {
+	o, err := s.s3c.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]string{}
+	for k, v := range o.Metadata {
+		if v != nil {
+			m[strings.ToLower(k)] = *v
+		}
+	}
+	return m, nil
+}
+
+func (s *s3Storage) Close() error {
+	return nil
+}
+
+// signatureFileSuffix is the suffix of the file next to a local digest file which has the signature in the metadata of the S3 object (eg. xxx.json.gz.signature)
+const signatureFileSuffix = ".signature"
+
+// dirStorage is a Storage of the local directory (eg. downloaded by `aws s3 sync`)
+type dirStorage struct {
+	root    string
+	cleanup bool
+}
+
+func (s *dirStorage) ListObjects(ctx context.Context, prefix, startAfter string, fn WalkObjectsFunc) error {
+	dir := prefix
+	if !strings.HasSuffix(prefix, "/") {
+		dir = path.Dir(prefix)
+	}
+	base := filepath.Join(s.root, filepath.FromSlash(dir))
+	if _, err := os.Stat(base); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	err := filepath.WalkDir(base, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) || key <= startAfter || strings.HasSuffix(key, signatureFileSuffix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(&s3.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(fi.Size()),
+			LastModified: aws.Time(fi.ModTime()),
+		})
+	})
+	if errors.Is(err, errStopListing) {
+		return nil
+	}
+	return err
+}
+
+func (s *dirStorage) ListCommonPrefixes(ctx context.Context, prefix string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(s.root, filepath.FromSlash(prefix)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *dirStorage) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return os.Open(filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+key))))
+}
+
+// GetObjectMetadata returns the signature in the signature file next to the local file because local files do not have the metadata of S3 objects
+func (s *dirStorage) GetObjectMetadata(ctx context.Context, key string) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p := filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+key)))
+	if _, err := os.Stat(p); err != nil {
+		return nil, err
+	}
+	m := map[string]string{}
+	b, err := os.ReadFile(p + signatureFileSuffix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, err
+	}
+	m["signature"] = strings.TrimSpace(string(b))
+	return m, nil
+}
+
+func (s *dirStorage) Close() error {
+	if !s.cleanup {
+		return nil
+	}
+	return os.RemoveAll(s.root)
+}
+
+// newTarStorage extracts the tarball (.tar, .tar.gz, .tgz) of trail logs into a temporary directory
+func newTarStorage(p string) (Storage, string, error) {
+	f, err := os.Open(filepath.Clean(p))
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	r, err := decompress(f)
+	if err != nil {
+		return nil, "", err
+	}
+	tmp, err := os.MkdirTemp("", "trail-digger-")
+	if err != nil {
+		return nil, "", err
+	}
+	s := &dirStorage{root: tmp, cleanup: true}
+	if err := extractTar(r, tmp); err != nil {
+		_ = s.Close()
+		return nil, "", fmt.Errorf("failed to extract %s: %w", p, err)
+	}
+	logs := ""
+	if err := filepath.WalkDir(tmp, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == defaultPrefix {
+			logs = p
+			return filepath.SkipDir
+		}
+		return nil
+	}); err != nil {
+		_ = s.Close()
+		return nil, "", err
+	}
+	if logs == "" {
+		_ = s.Close()
+		return nil, "", fmt.Errorf("%s directory not found in %s", defaultPrefix, p)
+	}
+	rel, err := filepath.Rel(tmp, logs)
+	if err != nil {
+		_ = s.Close()
+		return nil, "", err
+	}
+	return s, filepath.ToSlash(rel), nil
+}
+
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+h.Name)))
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(p, 0750); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(filepath.Clean(p), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+			if err != nil {
+				return err
+			}
+			if _, err := io.CopyN(f, tr, h.Size); err != nil {
+				_ = f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+			if err := os.Chtimes(p, time.Now(), h.ModTime); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package trail
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeTrailLog writes a trail log file with the same layout as the S3 bucket of AWS CloudTrail.
+// account can be prefixed with the organization ID (eg. o-xxxxxxxxxx/1234567890).
+func writeTrailLog(t *testing.T, root, account, region string, delivered time.Time, records ...string) string {
+	t.Helper()
+	key := fmt.Sprintf("AWSLogs/%s/CloudTrail/%s/%s/%s_CloudTrail_%s_%s_%d.json.gz", account, region, delivered.Format(datePathFormat), path.Base(account), region, delivered.Format("20060102T1504Z"), delivered.UnixNano())
+	p := filepath.Join(root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := gzip.NewWriter(f)
+	if _, err := fmt.Fprintf(gw, `{"Records":[%s]}`, strings.Join(records, ",")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testRecord(id string, et time.Time) string {
+	return fmt.Sprintf(`{"eventTime":"%s","eventID":"%s","eventSource":"iam.amazonaws.com","eventName":"ListUsers"}`, et.UTC().Format(time.RFC3339Nano), id)
+}
+
+func writeTarball(t *testing.T, dir, out string) {
+	t.Helper()
+	f, err := os.Create(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	if err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		h, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		h.Name = filepath.ToSlash(filepath.Join("export", rel))
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(b)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWalkEventsLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	d := time.Date(2022, 2, 3, 0, 0, 0, 0, time.UTC)
+	writeTrailLog(t, dir, "123456789012", "ap-northeast-1", d.Add(10*time.Hour),
+		testRecord("b", d.Add(9*time.Hour+55*time.Minute)),
+		testRecord("a", d.Add(9*time.Hour+50*time.Minute)),
+	)
+	writeTrailLog(t, dir, "123456789012", "us-east-1", d.Add(8*time.Hour), testRecord("c", d.Add(7*time.Hour)))
+	writeTrailLog(t, dir, "210987654321", "ap-northeast-1", d.Add(23*time.Hour+59*time.Minute), testRecord("d", d.Add(23*time.Hour+50*time.Minute)))
+	// delivered the following day
+	writeTrailLog(t, dir, "123456789012", "ap-northeast-1", d.Add(24*time.Hour+5*time.Minute),
+		testRecord("e", d.Add(23*time.Hour+58*time.Minute)),
+		testRecord("f", d.Add(24*time.Hour+1*time.Minute)),
+	)
+	tarball := filepath.Join(t.TempDir(), "trail.tar.gz")
+	writeTarball(t, dir, tarball)
+
+	tests := []struct {
+		dsn  string
+		opt  Option
+		want []string
+	}{
+		{"file://" + filepath.Join(dir, "AWSLogs"), Option{DatePath: "2022/02/03"}, []string{"c", "a", "b", "d", "e"}},
+		{"file://" + dir, Option{DatePath: "2022/02/03"}, []string{"c", "a", "b", "d", "e"}},
+		{"file://" + dir, Option{DatePath: "2022/02/03", Accounts: []string{"123456789012"}}, []string{"c", "a", "b", "e"}},
+		{"file://" + dir, Option{DatePath: "2022/02/03", Regions: []string{"ap-northeast-1"}}, []string{"a", "b", "d", "e"}},
+		{"file://" + tarball, Option{DatePath: "2022/02/03"}, []string{"c", "a", "b", "d", "e"}},
+	}
+	for _, tt := range tests {
+		got := []string{}
+		if err := WalkEvents(nil, tt.dsn, tt.opt, func(r *Record) error {
+			got = append(got, r.EventID)
+			return nil
+		}); err != nil {
+			t.Error(err)
+			continue
+		}
+		if diff := cmp.Diff(got, tt.want, nil); diff != "" {
+			t.Errorf("%s: %s", tt.dsn, diff)
+		}
+	}
+}
+
+func TestWalkObjectsLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	d := time.Date(2022, 2, 3, 0, 0, 0, 0, time.UTC)
+	k1 := writeTrailLog(t, dir, "123456789012", "ap-northeast-1", d.Add(10*time.Hour), testRecord("a", d))
+	k2 := writeTrailLog(t, dir, "123456789012", "ap-northeast-1", d.Add(11*time.Hour), testRecord("b", d))
+	_ = writeTrailLog(t, dir, "123456789012", "ap-northeast-1", d.Add(24*time.Hour), testRecord("c", d))
+	got := []string{}
+	if err := WalkObjects(nil, "file://"+dir, Option{DatePath: "2022/02/03"}, func(o *s3.Object) error {
+		got = append(got, *o.Key)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, []string{k1, k2}, nil); diff != "" {
+		t.Errorf("%s", diff)
+	}
+}
+
+func TestWalkEventsOrganizationTrail(t *testing.T) {
+	dir := t.TempDir()
+	d := time.Date(2022, 2, 3, 0, 0, 0, 0, time.UTC)
+	writeTrailLog(t, dir, "o-abcdefghij/123456789012", "ap-northeast-1", d.Add(10*time.Hour), testRecord("a", d.Add(9*time.Hour)))
+	writeTrailLog(t, dir, "o-abcdefghij/210987654321", "us-east-1", d.Add(11*time.Hour), testRecord("b", d.Add(10*time.Hour)))
+	writeTrailLog(t, dir, "111111111111", "ap-northeast-1", d.Add(12*time.Hour), testRecord("c", d.Add(11*time.Hour)))
+
+	tests := []struct {
+		opt     Option
+		want    []string
+		wantErr bool
+	}{
+		{Option{DatePath: "2022/02/03"}, []string{"a", "b", "c"}, false},
+		{Option{DatePath: "2022/02/03", AllAccounts: true, AllRegions: true}, []string{"a", "b", "c"}, false},
+		{Option{DatePath: "2022/02/03", Organization: "o-abcdefghij"}, []string{"a", "b"}, false},
+		{Option{DatePath: "2022/02/03", Accounts: []string{"210987654321", "111111111111"}}, []string{"b", "c"}, false},
+		{Option{DatePath: "2022/02/03", Organization: "o-abcdefghij", Accounts: []string{"123456789012"}}, []string{"a"}, false},
+		{Option{DatePath: "2022/02/03", Organization: "o-abcdefghij", Accounts: []string{"111111111111"}}, nil, true},
+	}
+	for _, tt := range tests {
+		got := []string{}
+		err := WalkEvents(nil, "file://"+dir, tt.opt, func(r *Record) error {
+			got = append(got, r.EventID)
+			return nil
+		})
+		if err != nil {
+			if !tt.wantErr {
+				t.Error(err)
+			}
+			continue
+		}
+		if tt.wantErr {
+			t.Errorf("%v: want error", tt.opt)
+			continue
+		}
+		if diff := cmp.Diff(got, tt.want, nil); diff != "" {
+			t.Errorf("%v: %s", tt.opt, diff)
+		}
+	}
+}
+
+func TestWalkEventsWithContextCancel(t *testing.T) {
+	dir := t.TempDir()
+	d := time.Date(2022, 2, 3, 0, 0, 0, 0, time.UTC)
+	writeTrailLog(t, dir, "123456789012", "ap-northeast-1", d.Add(10*time.Hour), testRecord("a", d.Add(9*time.Hour)), testRecord("b", d.Add(9*time.Hour+1*time.Minute)), testRecord("c", d.Add(9*time.Hour+2*time.Minute)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := []string{}
+	err := WalkEventsWithContext(ctx, nil, "file://"+dir, Option{DatePath: "2022/02/03"}, func(r *Record) error {
+		got = append(got, r.EventID)
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v want %v", err, context.Canceled)
+	}
+	if diff := cmp.Diff(got, []string{"a"}, nil); diff != "" {
+		t.Errorf("%s", diff)
+	}
+
+	if err := WalkObjectsWithContext(ctx, nil, "file://"+dir, Option{DatePath: "2022/02/03"}, func(o *s3.Object) error {
+		t.Error("should not be called")
+		return nil
+	}); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,104 @@
+package trail
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultDeliveryDelay is the max delay assumed from events to the delivery of the trail log file containing them
+const defaultDeliveryDelay = time.Hour
+
+// ParseTime parses the time in RFC3339 (eg. 2022-02-03T14:05:00Z) or the duration before now (eg. 30m, 2h, 7d)
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		d, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err == nil && d >= 0 {
+			return now.AddDate(0, 0, -d), nil
+		}
+	} else if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time format: %s", s)
+}
+
+// ParseDuration parses the duration (eg. 5m, 1h) including days (eg. 1d)
+func ParseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		d, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("invalid duration: %s", s)
+		}
+		return time.Duration(d) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration: %s", s)
+	}
+	return d, nil
+}
+
+// timeRange returns the range of Since and Until. If Until is zero, it is the current time.
+func timeRange(opt Option) (time.Time, time.Time, error) {
+	until := opt.Until
+	if until.IsZero() {
+		until = time.Now()
+	}
+	if until.Before(opt.Since) {
+		return time.Time{}, time.Time{}, fmt.Errorf("until (%s) is before since (%s)", until.Format(time.RFC3339), opt.Since.Format(time.RFC3339))
+	}
+	return opt.Since, until, nil
+}
+
+var dayPrefixRe = regexp.MustCompile(`(?:^|/)([0-9]+)/CloudTrail/([a-z0-9\-]+)/[0-9]{4}/[0-9]{2}/[0-9]{2}/$`)
+
+// deliveryWindow prunes trail log files by the delivery time in the file name.
+// A trail log file delivered at t contains the events between t - delay and t.
+type deliveryWindow struct {
+	since time.Time
+	until time.Time
+	delay time.Duration
+}
+
+func newDeliveryWindow(opt Option, since, until time.Time) *deliveryWindow {
+	delay := opt.DeliveryDelay
+	if delay <= 0 {
+		delay = defaultDeliveryDelay
+	}
+	return &deliveryWindow{since: since, until: until, delay: delay}
+}
+
+// startAfter returns the key to start listing trail log files under the day prefix (eg. AWSLogs/1234567890/CloudTrail/ap-northeast-1/2022/02/03/).
+// The file names under the day prefix are in order of delivery time (eg. 1234567890_CloudTrail_ap-northeast-1_20220203T1405Z_xxxx.json.gz).
+func (w *deliveryWindow) startAfter(prefix string) string {
+	if w == nil {
+		return ""
+	}
+	matches := dayPrefixRe.FindStringSubmatch(prefix)
+	if matches == nil {
+		return ""
+	}
+	// The time in the file name is truncated to minutes
+	t := w.since.Add(-time.Minute).UTC()
+	return fmt.Sprintf("%s%s_CloudTrail_%s_%s", prefix, matches[1], matches[2], t.Format(deliveredFormat))
+}
+
+// check reports whether the trail log file can be skipped, and whether the files after it in order of key can be skipped too
+func (w *deliveryWindow) check(key string) (skip bool, stop bool) {
+	if w == nil {
+		return false, false
+	}
+	k, err := ParseObjectKey(key)
+	if err != nil || k.Delivered.IsZero() {
+		return false, false
+	}
+	if k.Delivered.Add(-w.delay).After(w.until) {
+		return true, true
+	}
+	return k.Delivered.Add(time.Minute).Before(w.since), false
+}
@@ -0,0 +1,207 @@
+package trail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2022, 2, 3, 14, 5, 0, 0, time.UTC)
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{"2022-02-03T14:05:00Z", time.Date(2022, 2, 3, 14, 5, 0, 0, time.UTC), false},
+		{"2022-02-03T15:30:00+09:00", time.Date(2022, 2, 3, 6, 30, 0, 0, time.UTC), false},
+		{"2h", time.Date(2022, 2, 3, 12, 5, 0, 0, time.UTC), false},
+		{"1h30m", time.Date(2022, 2, 3, 12, 35, 0, 0, time.UTC), false},
+		{"7d", time.Date(2022, 1, 27, 14, 5, 0, 0, time.UTC), false},
+		{"2022/02/03", time.Time{}, true},
+		{"-2h", time.Time{}, true},
+		{"xd", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTime(tt.in, now)
+		if err != nil {
+			if !tt.wantErr {
+				t.Error(err)
+			}
+			continue
+		}
+		if tt.wantErr {
+			t.Errorf("%s: want error", tt.in)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%s: got %v want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"5m", 5 * time.Minute, false},
+		{"1h", time.Hour, false},
+		{"1d", 24 * time.Hour, false},
+		{"-1h", 0, true},
+		{"1w", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got %v want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %v want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDatePathsSinceUntil(t *testing.T) {
+	tests := []struct {
+		opt       Option
+		after1Day bool
+		want      []string
+		wantErr   bool
+	}{
+		{Option{Since: time.Date(2022, 2, 3, 14, 5, 0, 0, time.UTC), Until: time.Date(2022, 2, 3, 15, 0, 0, 0, time.UTC)}, false, []string{"2022/02/03"}, false},
+		{Option{Since: time.Date(2022, 2, 3, 14, 5, 0, 0, time.UTC), Until: time.Date(2022, 2, 3, 15, 0, 0, 0, time.UTC)}, true, []string{"2022/02/03", "2022/02/04"}, false},
+		{Option{Since: time.Date(2022, 2, 28, 23, 0, 0, 0, time.UTC), Until: time.Date(2022, 3, 1, 1, 0, 0, 0, time.UTC)}, false, []string{"2022/02/28", "2022/03/01"}, false},
+		// 2022-02-04T08:00:00+09:00 is 2022-02-03T23:00:00Z
+		{Option{Since: time.Date(2022, 2, 4, 8, 0, 0, 0, time.FixedZone("JST", 9*60*60)), Until: time.Date(2022, 2, 4, 10, 0, 0, 0, time.UTC)}, false, []string{"2022/02/03", "2022/02/04"}, false},
+		{Option{Since: time.Date(2022, 2, 3, 14, 5, 0, 0, time.UTC), Until: time.Date(2022, 2, 3, 13, 0, 0, 0, time.UTC)}, false, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := datePaths(tt.opt, tt.after1Day)
+		if err != nil {
+			if !tt.wantErr {
+				t.Error(err)
+			}
+			continue
+		}
+		if tt.wantErr {
+			t.Error("want error")
+			continue
+		}
+		if diff := cmp.Diff(got, tt.want, nil); diff != "" {
+			t.Errorf("%s", diff)
+		}
+	}
+}
+
+func TestWalkEventsSinceUntil(t *testing.T) {
+	dir := t.TempDir()
+	d := time.Date(2022, 2, 3, 0, 0, 0, 0, time.UTC)
+	a := "123456789012"
+	r := "ap-northeast-1"
+	writeTrailLog(t, dir, a, r, d.Add(14*time.Hour), testRecord("a", d.Add(13*time.Hour+58*time.Minute)), testRecord("b", d.Add(14*time.Hour+4*time.Minute)))
+	writeTrailLog(t, dir, a, r, d.Add(14*time.Hour+10*time.Minute), testRecord("c", d.Add(14*time.Hour+6*time.Minute)))
+	writeTrailLog(t, dir, a, r, d.Add(15*time.Hour+35*time.Minute), testRecord("d", d.Add(15*time.Hour+29*time.Minute)), testRecord("e", d.Add(15*time.Hour+31*time.Minute)))
+	// broken files out of range are not downloaded
+	for _, k := range []string{
+		"AWSLogs/123456789012/CloudTrail/ap-northeast-1/2022/02/03/123456789012_CloudTrail_ap-northeast-1_20220203T1000Z_broken.json.gz",
+		"AWSLogs/123456789012/CloudTrail/ap-northeast-1/2022/02/03/123456789012_CloudTrail_ap-northeast-1_20220203T1800Z_broken.json.gz",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(k))
+		if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("broken"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := []string{}
+	opt := Option{
+		Since: time.Date(2022, 2, 3, 14, 0, 0, 0, time.UTC),
+		Until: time.Date(2022, 2, 3, 15, 30, 0, 0, time.UTC),
+	}
+	if err := WalkEvents(nil, "file://"+dir, opt, func(r *Record) error {
+		got = append(got, r.EventID)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"b", "c", "d"}
+	if diff := cmp.Diff(got, want, nil); diff != "" {
+		t.Errorf("%s", diff)
+	}
+}
+
+func TestDeliveryWindow(t *testing.T) {
+	w := newDeliveryWindow(Option{}, time.Date(2022, 2, 3, 14, 5, 0, 0, time.UTC), time.Date(2022, 2, 3, 15, 30, 0, 0, time.UTC))
+	prefix := "AWSLogs/o-abcdefghij/123456789012/CloudTrail/ap-northeast-1/2022/02/03/"
+	if got, want := w.startAfter(prefix), prefix+"123456789012_CloudTrail_ap-northeast-1_20220203T1404Z"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got := w.startAfter("AWSLogs/123456789012/CloudTrail/"); got != "" {
+		t.Errorf("got %v want %v", got, "")
+	}
+
+	tests := []struct {
+		delivered string
+		wantSkip  bool
+		wantStop  bool
+	}{
+		{"20220203T1403Z", true, false},
+		{"20220203T1404Z", false, false},
+		{"20220203T1530Z", false, false},
+		{"20220203T1630Z", false, false},
+		{"20220203T1631Z", true, true},
+	}
+	for _, tt := range tests {
+		key := prefix + "123456789012_CloudTrail_ap-northeast-1_" + tt.delivered + "_abcdefghijklmnop.json.gz"
+		skip, stop := w.check(key)
+		if skip != tt.wantSkip || stop != tt.wantStop {
+			t.Errorf("%s: got (%v, %v) want (%v, %v)", tt.delivered, skip, stop, tt.wantSkip, tt.wantStop)
+		}
+	}
+	if skip, stop := w.check(prefix + "renamed.json.gz"); skip || stop {
+		t.Errorf("got (%v, %v) want (false, false)", skip, stop)
+	}
+
+	w = newDeliveryWindow(Option{DeliveryDelay: 3 * time.Hour}, time.Date(2022, 2, 3, 14, 5, 0, 0, time.UTC), time.Date(2022, 2, 3, 15, 30, 0, 0, time.UTC))
+	if skip, stop := w.check(prefix + "123456789012_CloudTrail_ap-northeast-1_20220203T1830Z_abcdefghijklmnop.json.gz"); skip || stop {
+		t.Errorf("got (%v, %v) want (false, false)", skip, stop)
+	}
+}
+
+func TestWalkEventsFollowingDay(t *testing.T) {
+	dir := t.TempDir()
+	d := time.Date(2022, 2, 3, 0, 0, 0, 0, time.UTC)
+	a := "123456789012"
+	r := "ap-northeast-1"
+	writeTrailLog(t, dir, a, r, d.Add(23*time.Hour), testRecord("a", d.Add(22*time.Hour+55*time.Minute)))
+	writeTrailLog(t, dir, a, r, d.Add(24*time.Hour+5*time.Minute), testRecord("b", d.Add(23*time.Hour+59*time.Minute)), testRecord("c", d.Add(24*time.Hour+time.Minute)))
+	// trail log files of the following day delivered within --delivery-delay are read for --date
+	writeTrailLog(t, dir, a, r, d.Add(24*time.Hour+50*time.Minute), testRecord("d", d.Add(23*time.Hour+50*time.Minute)))
+	// trail log files of the following day delivered later than --delivery-delay are not downloaded
+	writeTrailLog(t, dir, a, r, d.Add(27*time.Hour), testRecord("e", d.Add(23*time.Hour+58*time.Minute)))
+	k := "AWSLogs/123456789012/CloudTrail/ap-northeast-1/2022/02/04/123456789012_CloudTrail_ap-northeast-1_20220204T0300Z_broken.json.gz"
+	p := filepath.Join(dir, filepath.FromSlash(k))
+	if err := os.WriteFile(p, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	if err := WalkEvents(nil, "file://"+dir, Option{DatePath: "2022/02/03"}, func(r *Record) error {
+		got = append(got, r.EventID)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a", "d", "b"}
+	if diff := cmp.Diff(got, want, nil); diff != "" {
+		t.Errorf("%s", diff)
+	}
+}
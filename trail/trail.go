@@ -2,22 +2,21 @@ package trail
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"io"
+	"os"
 	"path"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/goccy/go-json"
 	"github.com/rs/zerolog/log"
-	"github.com/zhangyunhao116/skipmap"
-	"github.com/zhangyunhao116/wyhash"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 )
 
 const datePathFormat = "2006/01/02"
@@ -36,6 +35,16 @@ type Record struct {
 		AccountID   string `json:"accountId"`
 		AccessKeyID string `json:"accessKeyId"`
 		UserName    string `json:"userName"`
+		// SessionContext is the context of temporary security credentials (eg. role sessions)
+		SessionContext struct {
+			SessionIssuer struct {
+				Type        string `json:"type"`
+				PrincipalID string `json:"principalId"`
+				Arn         string `json:"arn"`
+				AccountID   string `json:"accountId"`
+				UserName    string `json:"userName"`
+			} `json:"sessionIssuer"`
+		} `json:"sessionContext"`
 	} `json:"userIdentity"`
 	EventTime         time.Time `json:"eventTime"`
 	EventSource       string    `json:"eventSource"`
@@ -76,6 +85,37 @@ type Record struct {
 	RecipientAccountID string `json:"recipientAccountId"`
 	SharedEventID      string `json:"sharedEventID"`
 	EventCategory      string `json:"eventCategory"`
+	ErrorCode          string `json:"errorCode,omitempty"`
+	ErrorMessage       string `json:"errorMessage,omitempty"`
+
+	// Raw is the record as written by CloudTrail, compacted into a line
+	Raw json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON decodes the typed fields and keeps the original bytes in Raw.
+// The bytes are compacted so that records of pretty-printed trail logs can be written as JSONL.
+func (r *Record) UnmarshalJSON(b []byte) error {
+	type record Record
+	rr := record{}
+	if err := json.Unmarshal(b, &rr); err != nil {
+		return err
+	}
+	*r = Record(rr)
+	buf := new(bytes.Buffer)
+	if err := json.Compact(buf, b); err != nil {
+		return err
+	}
+	r.Raw = buf.Bytes()
+	return nil
+}
+
+// MarshalRaw returns the record as written by CloudTrail.
+// If the record was not decoded from trail logs, it returns the typed fields as JSON.
+func (r *Record) MarshalRaw() ([]byte, error) {
+	if len(r.Raw) > 0 {
+		return r.Raw, nil
+	}
+	return json.Marshal(r)
 }
 
 type Option struct {
@@ -86,129 +126,253 @@ type Option struct {
 	Regions       []string
 	AllAccounts   bool
 	AllRegions    bool
+	Organization  string
+	Filter        string
+	// Since and Until are the range of event time. If Since is set, it takes precedence over the date paths.
+	// If Until is zero, it is the current time
+	Since time.Time
+	Until time.Time
+	// DeliveryDelay is the max delay assumed from events to the delivery of the trail log file containing them.
+	// Trail log files are pruned by the delivery time in the file name with it: all of them if Since is set, or those of the following day of the date paths.
+	// If it is 0, 1 hour is used
+	DeliveryDelay time.Duration
+	// Concurrency is the max number of concurrent requests to list or get trail log files
+	Concurrency int
+	// MaxRetries is the max number of retries to get a trail log file. A negative value disables retries
+	MaxRetries int
+	// Checkpoint records the progress of WalkEvents to resume it
+	Checkpoint *Checkpoint
+	// MaxMemory is the max bytes of events held in memory to sort them. Events over it are spilled to temporary files.
+	// If it is 0, all events are held in memory
+	MaxMemory int64
 }
 
 type WalkEventsFunc func(r *Record) error
 
+// WalkEvents walks the events of trail logs in order of timeline
 func WalkEvents(sess *session.Session, dsn string, opt Option, fn WalkEventsFunc) error {
-	bucket, prefixes, err := generatePrefixes(sess, dsn, opt, true)
-	if err != nil {
-		return err
-	}
-
-	s3c := s3.New(sess)
-	var t *string
-	em := map[string]*skipmap.Float64Map{}
+	return WalkEventsWithContext(context.Background(), sess, dsn, opt, fn)
+}
 
-	days, err := datePaths(opt, true)
+// WalkEventsWithContext walks the events of trail logs in order of timeline.
+// When ctx is canceled, it stops downloading trail logs and calling fn, and returns the error of ctx.
+func WalkEventsWithContext(ctx context.Context, sess *session.Session, dsn string, opt Option, fn WalkEventsFunc) error {
+	var f *Filter
+	if opt.Filter != "" {
+		var err error
+		f, err = CompileFilter(opt.Filter)
+		if err != nil {
+			return err
+		}
+	}
+	s, prefix, err := NewStorage(sess, dsn)
 	if err != nil {
 		return err
 	}
-	st, err := time.Parse("2006/01/02", days[0])
+	defer func() {
+		_ = s.Close()
+	}()
+	prefixes, err := generatePrefixes(ctx, sess, s, prefix, opt, true)
 	if err != nil {
 		return err
 	}
-	et, err := time.Parse("2006/01/02", days[len(days)-1])
+
+	tmpDir := ""
+	if opt.MaxMemory > 0 {
+		tmpDir, err = os.MkdirTemp("", "trail-digger-")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = os.RemoveAll(tmpDir)
+		}()
+	}
+	// The events of 2 days are collected at the same time, so each day can use half of MaxMemory
+	maxMemory := opt.MaxMemory / 2
+
+	em := map[string]*eventSorter{}
+
+	days, err := datePaths(opt, true)
 	if err != nil {
 		return err
 	}
+	var st, et time.Time
+	// Trail log files of the target days are pruned only if the range of event time is specified
+	var w, fw *deliveryWindow
+	if opt.Since.IsZero() {
+		st, err = time.Parse("2006/01/02", days[0])
+		if err != nil {
+			return err
+		}
+		et, err = time.Parse("2006/01/02", days[len(days)-1])
+		if err != nil {
+			return err
+		}
+		// The following day is only for the trail log files delivered late
+		fw = newDeliveryWindow(opt, et, et)
+	} else {
+		st, et, err = timeRange(opt)
+		if err != nil {
+			return err
+		}
+		w = newDeliveryWindow(opt, st, et)
+	}
 	stn := st.UnixNano()
 	etn := et.UnixNano()
 
+	store := func(td *LogData) error {
+		for _, r := range td.Records {
+			tf := r.EventTime.Format(datePathFormat)
+			tn := r.EventTime.UnixNano()
+			if tn < stn || etn < tn {
+				continue
+			}
+			if f != nil {
+				m, err := f.Match(r)
+				if err != nil {
+					return err
+				}
+				if !m {
+					continue
+				}
+			}
+			k := eventKey(r)
+			m, ok := em[tf]
+			if !ok || m == nil {
+				// the day already walked
+				continue
+			}
+			if err := m.store(k, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	listSem := semaphore.NewWeighted(concurrency(opt))
+	getSem := semaphore.NewWeighted(concurrency(opt))
+	retries := maxRetries(opt)
+
+	cp := opt.Checkpoint
+	emit := func(day string) error {
+		m, ok := em[day]
+		if !ok || m == nil {
+			return nil
+		}
+		em[day] = nil
+		defer func() {
+			_ = m.close()
+		}()
+		offset := 0
+		if cp != nil {
+			offset = cp.offset(day)
+		}
+		n := 0
+		var err error
+		if werr := m.walk(func(r *Record) bool {
+			n += 1
+			if n <= offset {
+				return true
+			}
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+			err = fn(r)
+			if err != nil {
+				log.Debug().Err(err)
+				return false
+			}
+			return true
+		}); werr != nil {
+			return werr
+		}
+		if cp == nil {
+			return err
+		}
+		if err != nil {
+			cp.progress(day, n-1)
+			if err := cp.Save(); err != nil {
+				log.Error().Err(err).Msg("Failed to save checkpoint")
+			}
+			return err
+		}
+		cp.done(day)
+		return cp.Save()
+	}
+
 	for _, pd := range prefixes {
 		day := pd.day.Format(datePathFormat)
-		em[day] = skipmap.NewFloat64()
-		eg := errgroup.Group{}
+		ptd := pd.day.AddDate(0, 0, -1).Format(datePathFormat)
+		if cp == nil || !cp.isDone(day) {
+			em[day] = newEventSorter(tmpDir, maxMemory)
+		}
+		if em[day] == nil && em[ptd] == nil {
+			log.Info().Str("day", day).Msg("Skip the day already walked")
+			continue
+		}
+		w := w
+		if fw != nil && !pd.day.Before(et) {
+			w = fw
+		}
+		eg, ctx := errgroup.WithContext(ctx)
 		for _, prefix := range pd.prefixes {
-			log.Info().Str("prefix", prefix).Msg("Digging trail logs")
-			func(bucket, prefix string) {
+			func(prefix string) {
 				eg.Go(func() error {
-					for {
-						o, err := s3c.ListObjectsV2(&s3.ListObjectsV2Input{
-							Bucket:            aws.String(bucket),
-							Prefix:            aws.String(prefix),
-							ContinuationToken: t,
-						})
-						if err != nil {
+					if err := listSem.Acquire(ctx, 1); err != nil {
+						return err
+					}
+					defer listSem.Release(1)
+					log.Info().Str("prefix", prefix).Msg("Digging trail logs")
+					return s.ListObjects(ctx, prefix, w.startAfter(prefix), func(c *s3.Object) error {
+						key := *c.Key
+						skip, stop := w.check(key)
+						if stop {
+							return errStopListing
+						}
+						if skip {
+							return nil
+						}
+						if err := getSem.Acquire(ctx, 1); err != nil {
 							return err
 						}
-						for _, c := range o.Contents {
-							obj, err := s3c.GetObject(&s3.GetObjectInput{
-								Bucket: aws.String(bucket),
-								Key:    c.Key,
-							})
-							if err != nil {
-								return err
-							}
-							buf := new(bytes.Buffer)
-							if _, err := io.Copy(buf, obj.Body); err != nil {
-								_ = obj.Body.Close()
+						eg.Go(func() error {
+							defer getSem.Release(1)
+							var td *LogData
+							if err := retry(ctx, retries, func() error {
+								var err error
+								td, err = getLogData(ctx, s, key)
 								return err
-							}
-							if err := obj.Body.Close(); err != nil {
+							}); err != nil {
 								return err
 							}
-							td := LogData{}
-							if err := json.Unmarshal(buf.Bytes(), &td); err != nil {
-								return err
-							}
-							for _, r := range td.Records {
-								tf := r.EventTime.Format(datePathFormat)
-								tn := r.EventTime.UnixNano()
-								if tn < stn || etn < tn {
-									continue
-								}
-								k, err := strconv.ParseFloat(fmt.Sprintf("%d.%d", r.EventTime.Unix(), wyhash.Sum64String(r.EventID)), 64)
-								if err != nil {
-									return err
-								}
-								em[tf].Store(k, r)
-							}
-						}
-						if o.NextContinuationToken == nil {
-							break
-						}
-						t = o.NextContinuationToken
-					}
-					return nil
+							return store(td)
+						})
+						return nil
+					})
 				})
-			}(bucket, prefix)
+			}(prefix)
 		}
 		if err := eg.Wait(); err != nil {
 			return err
 		}
-		ptd := pd.day.AddDate(0, 0, -1).Format(datePathFormat)
-		if prev, ok := em[ptd]; ok {
-			var err error
-			prev.Range(func(k float64, v interface{}) bool {
-				r := v.(*Record)
-				err = fn(r)
-				if err != nil {
-					log.Debug().Err(err)
-					return false
-				}
-				return true
-			})
-			if err != nil {
-				return err
-			}
-			em[ptd] = nil
+		if err := emit(ptd); err != nil {
+			return err
 		}
 	}
 	ld := prefixes[len(prefixes)-1].day.Format(datePathFormat)
-	em[ld].Range(func(k float64, v interface{}) bool {
-		r := v.(*Record)
-		err = fn(r)
-		if err != nil {
-			log.Debug().Err(err)
-			return false
-		}
-		return true
-	})
+	if err := emit(ld); err != nil {
+		return err
+	}
 
 	return nil
 }
 
+// eventKey returns the key to sort events in order of timeline.
+// Events at the same time are ordered by eventID, and the same event delivered more than once has the same key.
+func eventKey(r *Record) string {
+	return fmt.Sprintf("%020d\x00%s", r.EventTime.UnixNano(), r.EventID)
+}
+
 type Prefixes []*PrefixesGroupPerDay
 
 type PrefixesGroupPerDay struct {
@@ -216,68 +380,41 @@ type PrefixesGroupPerDay struct {
 	prefixes []string
 }
 
-// generatePrefixes generate prefix per day order day
-func generatePrefixes(sess *session.Session, dsn string, opt Option, after1Day bool) (string, Prefixes, error) {
-	if !strings.HasPrefix(dsn, "s3://") {
-		return "", nil, fmt.Errorf("invalid s3 bucket url: %s", dsn)
+// getLogData gets the trail log file and decodes it
+func getLogData(ctx context.Context, s Storage, key string) (*LogData, error) {
+	b, err := readObject(ctx, s, key)
+	if err != nil {
+		return nil, err
 	}
-	splitted := strings.SplitN(strings.TrimPrefix(dsn, "s3://"), "/", 2)
-	if len(splitted) == 0 || splitted[0] == "" {
-		return "", nil, fmt.Errorf("invalid s3 bucket url: %s", dsn)
+	td := &LogData{}
+	if err := json.Unmarshal(b, td); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", key, err)
 	}
+	return td, nil
+}
+
+// generatePrefixes generate prefix per day order day
+func generatePrefixes(ctx context.Context, sess *session.Session, s Storage, prefix string, opt Option, after1Day bool) (Prefixes, error) {
 	days, err := datePaths(opt, after1Day)
 	if err != nil {
-		return "", nil, err
-	}
-	bucket := splitted[0]
-	prefix := "AWSLogs"
-	if len(splitted) > 1 && splitted[1] != "" {
-		prefix = splitted[1]
-	}
-	s3c := s3.New(sess)
-
-	accounts := []string{}
-	switch {
-	case opt.AllAccounts:
-		o, err := s3c.ListObjectsV2(&s3.ListObjectsV2Input{
-			Bucket:    aws.String(bucket),
-			Prefix:    aws.String(fmt.Sprintf("%s/", prefix)),
-			Delimiter: aws.String("/"),
-		})
-		if err != nil {
-			return "", nil, err
-		}
-		for _, p := range o.CommonPrefixes {
-			accounts = append(accounts, strings.Trim(strings.Replace(*p.Prefix, prefix, "", -1), "/"))
-		}
-	case len(opt.Accounts) > 0:
-		accounts = opt.Accounts
-	default:
-		stsc := sts.New(sess)
-		i, err := stsc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
-		if err != nil {
-			return "", nil, err
-		}
-		accountId := *i.Account
-		accounts = []string{accountId}
+		return nil, err
+	}
+	// Trail logs in local storage have no caller identity or default region, so all of them are targeted by default
+	_, remote := s.(*s3Storage)
+
+	accountDirs, err := generateAccountDirs(ctx, sess, s, prefix, opt, remote)
+	if err != nil {
+		return nil, err
 	}
 
 	roots := []string{}
-	for _, a := range accounts {
+	for _, a := range accountDirs {
 		regions := []string{}
 		switch {
-		case opt.AllRegions:
-			prefix := fmt.Sprintf("%s/", path.Join(prefix, a, "CloudTrail"))
-			o, err := s3c.ListObjectsV2(&s3.ListObjectsV2Input{
-				Bucket:    aws.String(bucket),
-				Prefix:    aws.String(prefix),
-				Delimiter: aws.String("/"),
-			})
+		case opt.AllRegions || (!remote && len(opt.Regions) == 0):
+			regions, err = s.ListCommonPrefixes(ctx, path.Join(a, "CloudTrail"))
 			if err != nil {
-				return "", nil, err
-			}
-			for _, p := range o.CommonPrefixes {
-				regions = append(regions, strings.Trim(strings.Replace(*p.Prefix, prefix, "", -1), "/"))
+				return nil, err
 			}
 		case len(opt.Regions) > 0:
 			regions = opt.Regions
@@ -286,14 +423,14 @@ func generatePrefixes(sess *session.Session, dsn string, opt Option, after1Day b
 			regions = []string{region}
 		}
 		for _, r := range regions {
-			roots = append(roots, path.Join(prefix, a, "CloudTrail", r))
+			roots = append(roots, path.Join(a, "CloudTrail", r))
 		}
 	}
 	prefixes := Prefixes{}
 	for _, d := range days {
 		dt, err := time.Parse(datePathFormat, d)
 		if err != nil {
-			return "", nil, err
+			return nil, err
 		}
 		pd := &PrefixesGroupPerDay{
 			day:      dt,
@@ -304,11 +441,111 @@ func generatePrefixes(sess *session.Session, dsn string, opt Option, after1Day b
 		}
 		prefixes = append(prefixes, pd)
 	}
-	return bucket, prefixes, nil
+	return prefixes, nil
+}
+
+// generateAccountDirs generate the directories of target accounts (eg. AWSLogs/1234567890, AWSLogs/o-xxxxxxxxxx/1234567890)
+func generateAccountDirs(ctx context.Context, sess *session.Session, s Storage, prefix string, opt Option, remote bool) ([]string, error) {
+	// The trail logs of the organization trail are under AWSLogs/o-xxxxxxxxxx/
+	orgDirs := []string{}
+	if opt.Organization != "" {
+		orgDirs = append(orgDirs, path.Join(prefix, opt.Organization))
+	} else {
+		names, err := s.ListCommonPrefixes(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range names {
+			if isOrganizationID(n) {
+				orgDirs = append(orgDirs, path.Join(prefix, n))
+			}
+		}
+	}
+
+	if opt.AllAccounts || (!remote && len(opt.Accounts) == 0) {
+		dirs := []string{}
+		bases := orgDirs
+		if opt.Organization == "" {
+			bases = append([]string{prefix}, orgDirs...)
+		}
+		for _, b := range bases {
+			names, err := s.ListCommonPrefixes(ctx, b)
+			if err != nil {
+				return nil, err
+			}
+			for _, n := range names {
+				if isOrganizationID(n) {
+					continue
+				}
+				dirs = append(dirs, path.Join(b, n))
+			}
+		}
+		return dirs, nil
+	}
+
+	accounts := opt.Accounts
+	if len(accounts) == 0 {
+		stsc := sts.New(sess)
+		i, err := stsc.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+		if err != nil {
+			return nil, err
+		}
+		accounts = []string{*i.Account}
+	}
+	if len(orgDirs) == 0 {
+		dirs := []string{}
+		for _, a := range accounts {
+			dirs = append(dirs, path.Join(prefix, a))
+		}
+		return dirs, nil
+	}
+	members := map[string]string{}
+	for _, o := range orgDirs {
+		names, err := s.ListCommonPrefixes(ctx, o)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range names {
+			members[n] = o
+		}
+	}
+	dirs := []string{}
+	for _, a := range accounts {
+		o, ok := members[a]
+		switch {
+		case ok:
+			dirs = append(dirs, path.Join(o, a))
+		case opt.Organization != "":
+			return nil, fmt.Errorf("account %s not found in organization %s", a, opt.Organization)
+		default:
+			dirs = append(dirs, path.Join(prefix, a))
+		}
+	}
+	return dirs, nil
+}
+
+func isOrganizationID(name string) bool {
+	return strings.HasPrefix(name, "o-")
 }
 
 func datePaths(opt Option, after1Day bool) ([]string, error) {
 	paths := []string{}
+	if !opt.Since.IsZero() {
+		since, until, err := timeRange(opt)
+		if err != nil {
+			return []string{}, err
+		}
+		st := since.UTC()
+		et := until.UTC()
+		et = time.Date(et.Year(), et.Month(), et.Day(), 0, 0, 0, 0, time.UTC)
+		if after1Day {
+			et = et.AddDate(0, 0, 1)
+		}
+		for t := time.Date(st.Year(), st.Month(), st.Day(), 0, 0, 0, 0, time.UTC); !t.After(et); t = t.AddDate(0, 0, 1) {
+			paths = append(paths, t.Format(datePathFormat))
+		}
+		return paths, nil
+	}
 	if opt.StartDatePath != "" && opt.EndDatePath != "" {
 		st, err := time.Parse("2006/01/02", opt.StartDatePath)
 		if err != nil {
@@ -1,8 +1,11 @@
 package trail
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/goccy/go-json"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -38,3 +41,44 @@ func TestDatePaths(t *testing.T) {
 		}
 	}
 }
+
+func TestRecordRaw(t *testing.T) {
+	raw := `{"eventVersion":"1.08", "eventTime":"2022-02-03T14:05:00Z","eventSource":"iam.amazonaws.com","eventName":"CreateUser","errorCode":"AccessDenied","tlsDetails":{"tlsVersion":"TLSv1.2"},"requestParameters":{"userName":"alice"}}`
+	td := LogData{}
+	if err := json.Unmarshal([]byte(fmt.Sprintf(`{"Records":[%s]}`, raw)), &td); err != nil {
+		t.Fatal(err)
+	}
+	if len(td.Records) != 1 {
+		t.Fatalf("got %v want %v", len(td.Records), 1)
+	}
+	r := td.Records[0]
+	if r.EventName != "CreateUser" {
+		t.Errorf("got %v want %v", r.EventName, "CreateUser")
+	}
+	if r.ErrorCode != "AccessDenied" {
+		t.Errorf("got %v want %v", r.ErrorCode, "AccessDenied")
+	}
+	got, err := r.MarshalRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// compacted
+	want := strings.Replace(raw, `"1.08", "eventTime"`, `"1.08","eventTime"`, 1)
+	if string(got) != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+
+	// pretty-printed trail logs (eg. local files) are compacted into a line
+	indented := "{\n  \"Records\": [\n    {\n      \"eventID\": \"a\",\n      \"requestParameters\": {\n        \"userName\": \"alice bob\"\n      }\n    }\n  ]\n}\n"
+	td = LogData{}
+	if err := json.Unmarshal([]byte(indented), &td); err != nil {
+		t.Fatal(err)
+	}
+	got, err = td.Records[0].MarshalRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"eventID":"a","requestParameters":{"userName":"alice bob"}}`; string(got) != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+}